@@ -0,0 +1,119 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package api defines the types that a gobra server and its clients
+// exchange over HTTP and websockets. It depends only on the standard
+// library, so that clients, such as package client, do not depend on the
+// server and its dependencies.
+package api
+
+import (
+	"net/url"
+	"time"
+)
+
+// JobStatus is the state of a Job.
+type JobStatus string
+
+// These are the possible states of a Job.
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+	JobTimedOut  JobStatus = "timed-out"
+)
+
+// Job is a single execution of a command.
+type Job struct {
+	ID string `json:"id"`
+
+	// Commands is the command path, starting with the root command name,
+	// and Flags are the flag values it was run with, with the values of
+	// sensitive flags redacted.
+	Commands []string   `json:"commands"`
+	Flags    url.Values `json:"flags"`
+
+	// Args are the positional arguments of the command.
+	Args []string `json:"args,omitempty"`
+
+	// User is the user who started the job, if known, and RemoteAddr is
+	// the network address of the request that started it.
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+
+	// Schedule is the ID of the schedule that started the job, if any.
+	Schedule string `json:"schedule,omitempty"`
+
+	Status JobStatus `json:"status"`
+
+	// Start is the time the job started running or, while it is queued,
+	// the time it was submitted.
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end,omitempty"`
+
+	// Position is the position of the job in the queue while it is
+	// queued, starting at 1.
+	Position int `json:"position,omitempty"`
+
+	// Error is the error returned by the command, if any.
+	Error string `json:"error,omitempty"`
+
+	// Artifacts are the output files produced by the command.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+
+	// Uploads are the files passed to uploadable flags.
+	Uploads []Upload `json:"uploads,omitempty"`
+}
+
+// Artifact is an output file produced by a job.
+type Artifact struct {
+	// Name is the name of the output flag the file was written to.
+	Name string `json:"name"`
+
+	// Filename is the base name of the file.
+	Filename string `json:"filename"`
+
+	Size int64 `json:"size"`
+
+	// URL is the path the file can be downloaded from.
+	URL string `json:"url"`
+
+	// Path is the location of the file on the server, which is not
+	// shown to clients.
+	Path string `json:"-"`
+}
+
+// Upload is a file passed to an uploadable flag.
+type Upload struct {
+	// Flag is the name of the flag and Filename the base name of the
+	// file.
+	Flag     string `json:"flag"`
+	Filename string `json:"filename"`
+
+	// Path is the location of the file on the server, which is the value
+	// of the flag. It is not shown to clients.
+	Path string `json:"-"`
+}
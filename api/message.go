@@ -0,0 +1,110 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package api
+
+import (
+	"time"
+)
+
+// ProtocolVersion is the version of the Message format. It is increased
+// whenever a change is made that existing clients cannot handle.
+const ProtocolVersion = 1
+
+// MessageType identifies the kind of a Message.
+type MessageType string
+
+// These are the types of messages sent to clients.
+const (
+	// MessageOutput carries output written by a command to Stream.
+	MessageOutput MessageType = "output"
+
+	// MessageJobQueued is sent when a job waiting to run is put in the
+	// queue or moves up in it, with its Position.
+	MessageJobQueued MessageType = "job-queued"
+
+	// MessageJobStarted is sent when a job starts, with its Commands.
+	MessageJobStarted MessageType = "job-started"
+
+	// MessageJobFinished is sent when a job ends, with its Status and Error.
+	MessageJobFinished MessageType = "job-finished"
+
+	// MessageProgress carries progress reported with gobra.ReportProgress.
+	MessageProgress MessageType = "progress"
+
+	// MessageError reports a problem that prevented a job from running
+	// the command, such as an invalid flag value.
+	MessageError MessageType = "error"
+
+	// MessageHeartbeat is sent periodically so that clients can detect
+	// broken connections.
+	MessageHeartbeat MessageType = "heartbeat"
+)
+
+// Message is the JSON envelope of everything sent to clients over the
+// websocket. Fields that do not apply to a message type are omitted.
+type Message struct {
+	// Version is the ProtocolVersion of the message.
+	Version int `json:"v"`
+
+	Type MessageType `json:"type"`
+
+	// JobID is the ID of the job the message is about.
+	JobID string `json:"job,omitempty"`
+
+	Time time.Time `json:"time"`
+
+	// Seq numbers the messages of each job, starting at 1, so that clients
+	// can resume from the last message they received.
+	Seq int64 `json:"seq,omitempty"`
+
+	// Stream and Data are the name of the output stream ("stdout" or
+	// "stderr") and the output written to it.
+	Stream string `json:"stream,omitempty"`
+	Data   string `json:"data,omitempty"`
+
+	// Commands is the command path of a job that started.
+	Commands []string `json:"commands,omitempty"`
+
+	// Position is the position of a queued job, starting at 1.
+	Position int `json:"position,omitempty"`
+
+	// Status is the final status of a job.
+	Status JobStatus `json:"status,omitempty"`
+
+	// Error is the error message of a failed job or an error message.
+	Error string `json:"error,omitempty"`
+
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// Progress is the progress of a job.
+type Progress struct {
+	// Done is the amount of work done out of Total.
+	Done  float64 `json:"done"`
+	Total float64 `json:"total"`
+
+	// Message optionally describes the current step.
+	Message string `json:"message,omitempty"`
+}
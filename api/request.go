@@ -0,0 +1,52 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package api
+
+import "encoding/json"
+
+// CommandRequest is the JSON body of a POST request that runs a command.
+type CommandRequest struct {
+	// Flags holds the flag values by flag name. Slice flags take arrays,
+	// boolean flags booleans, numeric flags numbers and other flags
+	// strings.
+	Flags map[string]json.RawMessage `json:"flags"`
+
+	// Args are the positional arguments of the command.
+	Args []string `json:"args"`
+}
+
+// FieldError is the JSON response to a CommandRequest that is not valid.
+// Field is the offending field, such as "flags.num1" or "args".
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"error"`
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
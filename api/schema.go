@@ -0,0 +1,68 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package api
+
+// CommandSchema describes a command and its sub-commands, as served at
+// /schema for clients of the API.
+type CommandSchema struct {
+	Name  string `json:"name"`
+	Use   string `json:"use"`
+	Short string `json:"short,omitempty"`
+	Long  string `json:"long,omitempty"`
+
+	// Runnable is false for commands that only group sub-commands.
+	Runnable bool `json:"runnable,omitempty"`
+
+	// Flags are the flags declared by the command. Sub-commands also
+	// accept the persistent flags of their parents.
+	Flags []FlagSchema `json:"flags,omitempty"`
+
+	Commands []CommandSchema `json:"commands,omitempty"`
+}
+
+// FlagSchema describes a flag.
+type FlagSchema struct {
+	Name      string `json:"name"`
+	Shorthand string `json:"shorthand,omitempty"`
+
+	// Type is the pflag type of the flag, such as "string", "int" or
+	// "stringSlice".
+	Type  string `json:"type"`
+	Usage string `json:"usage,omitempty"`
+
+	// Default is the default value of the flag. It is empty for
+	// sensitive flags.
+	Default string `json:"default,omitempty"`
+
+	Persistent bool `json:"persistent,omitempty"`
+
+	// Uploadable flags take the paths of uploaded files, Archive flags
+	// the directories extracted from uploaded archives, Output flags are
+	// set by the server and Sensitive flags hold secrets.
+	Uploadable bool `json:"uploadable,omitempty"`
+	Archive    bool `json:"archive,omitempty"`
+	Output     bool `json:"output,omitempty"`
+	Sensitive  bool `json:"sensitive,omitempty"`
+}
@@ -0,0 +1,247 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// DefaultMaxArchiveSize is the default limit on the total number of
+	// bytes extracted from an uploaded archive.
+	DefaultMaxArchiveSize = 10 << 30
+
+	// DefaultMaxArchiveFiles is the default limit on the number of files
+	// and directories extracted from an uploaded archive.
+	DefaultMaxArchiveFiles = 10000
+)
+
+// errArchiveTooLarge is returned when extracting an archive would exceed
+// the configured limits.
+var errArchiveTooLarge = errors.New("gobra: archive exceeds the extraction limits")
+
+// MakeFlagArchive registers the given flag name(s) as taking a directory
+// that is uploaded as a .zip or .tar.gz archive. The flags become
+// uploadable, and uploaded archives are extracted into a new directory
+// whose path is passed to the flag. If an archive holds a single top-level
+// directory and nothing else, the path of that directory is used instead.
+func (s *Server) MakeFlagArchive(names ...string) {
+	s.MakeFlagUploadable(names...)
+	if s.archiveFlags == nil {
+		s.archiveFlags = make(map[string]struct{})
+	}
+	for _, name := range names {
+		s.archiveFlags[name] = struct{}{}
+	}
+}
+
+func (s *Server) isArchiveFlag(name string) bool {
+	_, ok := s.archiveFlags[name]
+	return ok
+}
+
+// extractUpload extracts the archive in r, which holds size bytes, into
+// a new directory under ArchiveDir and returns the directory path.
+func (s *Server) extractUpload(r io.ReaderAt, size int64) (string, error) {
+	dir, err := ioutil.TempDir(s.ArchiveDir, "gobra-archive")
+	if err != nil {
+		return "", fmt.Errorf("gobra: creating archive directory: %v", err)
+	}
+	maxSize, maxFiles := s.MaxArchiveSize, s.MaxArchiveFiles
+	if maxSize <= 0 {
+		maxSize = DefaultMaxArchiveSize
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultMaxArchiveFiles
+	}
+	x := &extractor{dir: dir, bytesLeft: maxSize, filesLeft: maxFiles}
+	if err = x.extract(r, size); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return singleSubdir(dir), nil
+}
+
+// singleSubdir returns the only entry of dir if that entry is a directory,
+// and dir otherwise.
+func singleSubdir(dir string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, entries[0].Name())
+}
+
+// extractor writes archive entries below dir while enforcing limits on
+// the number of entries and the number of bytes written, which guards
+// against decompression bombs.
+type extractor struct {
+	dir       string
+	bytesLeft int64
+	filesLeft int
+}
+
+func (x *extractor) extract(r io.ReaderAt, size int64) error {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("gobra: reading archive: %v", err)
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return x.extractZip(r, size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bufio.NewReader(io.NewSectionReader(r, 0, size)))
+		if err != nil {
+			return fmt.Errorf("gobra: reading gzip archive: %v", err)
+		}
+		defer gz.Close()
+		return x.extractTar(gz)
+	default:
+		return errors.New("gobra: unsupported archive format; use .zip or .tar.gz")
+	}
+}
+
+func (x *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("gobra: reading zip archive: %v", err)
+	}
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := x.mkdir(f.Name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if f.UncompressedSize64 > uint64(x.bytesLeft) {
+				return errArchiveTooLarge
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("gobra: reading %s from zip archive: %v", f.Name, err)
+			}
+			err = x.writeFile(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("gobra: archive entry %s is not a regular file or directory", f.Name)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("gobra: reading tar archive: %v", err)
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := x.mkdir(h.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if h.Size > x.bytesLeft {
+				return errArchiveTooLarge
+			}
+			if err := x.writeFile(h.Name, tr); err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return fmt.Errorf("gobra: archive entry %s is not a regular file or directory", h.Name)
+		}
+	}
+}
+
+// target returns the location of the named archive entry, making sure it
+// cannot escape the extraction directory ("zip slip").
+func (x *extractor) target(name string) (string, error) {
+	x.filesLeft--
+	if x.filesLeft < 0 {
+		return "", errArchiveTooLarge
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" ||
+		clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("gobra: archive entry %s is outside the extraction directory", name)
+	}
+	return filepath.Join(x.dir, clean), nil
+}
+
+func (x *extractor) mkdir(name string) error {
+	path, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0770)
+}
+
+func (x *extractor) writeFile(name string, r io.Reader) error {
+	path, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0660)
+	if err != nil {
+		return fmt.Errorf("gobra: extracting archive: %v", err)
+	}
+	// Read one byte past the limit to detect entries that are larger than
+	// their headers claim.
+	n, err := io.Copy(f, io.LimitReader(r, x.bytesLeft+1))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("gobra: extracting archive: %v", err)
+	}
+	x.bytesLeft -= n
+	if x.bytesLeft < 0 {
+		return errArchiveTooLarge
+	}
+	return nil
+}
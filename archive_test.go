@@ -0,0 +1,194 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"hash/crc32"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+)
+
+// archiveEntry is an entry of a test archive. size, if not zero, is the
+// size given in the entry's header instead of the real one.
+type archiveEntry struct {
+	name, body, link string
+	dir              bool
+	size             int64
+}
+
+func zipArchive(t *testing.T, entries ...archiveEntry) []byte {
+	b := new(bytes.Buffer)
+	w := zip.NewWriter(b)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		switch {
+		case e.dir:
+			h.Name += "/"
+			h.SetMode(os.ModeDir | 0755)
+		case e.link != "":
+			h.SetMode(os.ModeSymlink | 0777)
+			e.body = e.link
+		default:
+			h.SetMode(0644)
+		}
+		h.CRC32 = crc32.ChecksumIEEE([]byte(e.body))
+		h.CompressedSize64 = uint64(len(e.body))
+		h.UncompressedSize64 = uint64(len(e.body))
+		if e.size != 0 {
+			h.UncompressedSize64 = uint64(e.size)
+		}
+		f, err := w.CreateRaw(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(e.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func tarGzArchive(t *testing.T, entries ...archiveEntry) []byte {
+	b := new(bytes.Buffer)
+	gz := gzip.NewWriter(b)
+	w := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		switch {
+		case e.dir:
+			h.Typeflag, h.Mode = tar.TypeDir, 0755
+		case e.link != "":
+			h.Typeflag, h.Linkname = tar.TypeSymlink, e.link
+		}
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return b.Bytes()
+}
+
+func TestArchiveUpload(t *testing.T) {
+	entries := []archiveEntry{
+		{name: "shapes", dir: true},
+		{name: "shapes/a.shp", body: "shp"},
+		{name: "shapes/a.dbf", body: "dbf"},
+	}
+	for name, archive := range map[string][]byte{
+		"data.zip":    zipArchive(t, entries...),
+		"data.tar.gz": tarGzArchive(t, entries...),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ts := newServer(t, &gobra.Server{ArchiveDir: t.TempDir()})
+			p := filepath.Join(t.TempDir(), name)
+			if err := ioutil.WriteFile(p, archive, 0644); err != nil {
+				t.Fatal(err)
+			}
+			dir := ts.Upload("dir", p)
+			if filepath.Base(dir) != "shapes" || !strings.HasPrefix(dir, ts.Gobra.ArchiveDir) {
+				t.Errorf("extracted directory = %s", dir)
+			}
+			ts.Run("app/ls", url.Values{"dir": {dir}}).
+				ExpectStatus(gobra.JobSucceeded).
+				ExpectOutput("a.dbf\na.shp\n")
+		})
+	}
+}
+
+func TestArchiveUploadRejected(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		entries []archiveEntry
+	}{
+		{"parent directory", []archiveEntry{{name: "../evil.txt", body: "evil"}}},
+		{"nested parent directory", []archiveEntry{{name: "a/../../evil.txt", body: "evil"}}},
+		{"absolute path", []archiveEntry{{name: "/tmp/evil.txt", body: "evil"}}},
+		{"symlink", []archiveEntry{{name: "link", link: "/etc/passwd"}}},
+		{"too large", []archiveEntry{{name: "big.txt", body: strings.Repeat("x", 101)}}},
+		{"too many files", []archiveEntry{
+			{name: "1", body: "1"}, {name: "2", body: "2"}, {name: "3", body: "3"}, {name: "4", body: "4"},
+		}},
+	} {
+		for ext, archive := range map[string][]byte{
+			".zip":    zipArchive(t, test.entries...),
+			".tar.gz": tarGzArchive(t, test.entries...),
+		} {
+			t.Run(test.name+ext, func(t *testing.T) {
+				testArchiveRejected(t, archive, ext)
+			})
+		}
+	}
+
+	// The header of a zip entry can understate its size, which tar
+	// headers cannot.
+	t.Run("lying header.zip", func(t *testing.T) {
+		testArchiveRejected(t, zipArchive(t, archiveEntry{name: "big.txt", body: strings.Repeat("x", 1000), size: 10}), ".zip")
+	})
+}
+
+// testArchiveRejected checks that uploading the archive fails without
+// writing anything.
+func testArchiveRejected(t *testing.T, archive []byte, ext string) {
+	tmp := t.TempDir()
+	archiveDir := filepath.Join(tmp, "archives")
+	if err := os.Mkdir(archiveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ts := newServer(t, &gobra.Server{ArchiveDir: archiveDir, MaxArchiveSize: 100, MaxArchiveFiles: 3})
+	p := filepath.Join(tmp, "upload"+ext)
+	if err := ioutil.WriteFile(p, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if dir, err := ts.API().Upload(context.Background(), "dir", p); err == nil {
+		t.Errorf("archive extracted to %s", dir)
+	}
+	for _, dir := range []string{tmp, archiveDir} {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			if e.Name() != "archives" && e.Name() != filepath.Base(p) {
+				t.Errorf("%s left in %s", e.Name(), dir)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReplayBytes is the default number of bytes of messages kept
+	// for each job so that reconnecting clients can catch up.
+	DefaultReplayBytes = 256 << 10
+
+	// replayJobs is the number of jobs whose messages are kept.
+	replayJobs = 100
+
+	// messageOverhead is roughly how many bytes a message takes besides
+	// its data.
+	messageOverhead = 200
+
+	// subscriberBuffer is the number of messages that may be waiting to be
+	// sent to a subscriber. Subscribers that fall further behind are
+	// disconnected and have to reconnect, replaying what they missed.
+	subscriberBuffer = 256
+)
+
+// broadcaster fans messages out to every subscriber and keeps the most
+// recent messages of each job.
+type broadcaster struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+
+	// size is the number of bytes of messages kept for each job.
+	size int
+
+	logs map[string]*jobLog
+	// order holds the IDs of the jobs in logs, oldest first.
+	order []string
+}
+
+// subscriber receives the messages of one job, or of all jobs if job is
+// empty. ch is closed if the subscriber falls behind.
+type subscriber struct {
+	job string
+	ch  chan Message
+}
+
+// jobLog holds the most recent messages of a job.
+type jobLog struct {
+	// seq is the sequence number of the last message.
+	seq int64
+	buf []Message
+	// bytes is the size of the messages in buf.
+	bytes int
+}
+
+func messageSize(m Message) int {
+	return messageOverhead + len(m.Data) + len(m.Error)
+}
+
+// add stores m and drops the oldest messages so that no more than size
+// bytes are kept. A message larger than size is not kept at all.
+func (l *jobLog) add(m Message, size int) {
+	l.buf = append(l.buf, m)
+	l.bytes += messageSize(m)
+	for len(l.buf) > 0 && l.bytes > size {
+		l.bytes -= messageSize(l.buf[0])
+		// Release the data of the dropped message.
+		l.buf[0] = Message{}
+		l.buf = l.buf[1:]
+	}
+}
+
+// since returns the messages with a sequence number greater than seq, and
+// whether any such messages have already been dropped.
+func (l *jobLog) since(seq int64) (msgs []Message, lost bool) {
+	oldest := l.seq + 1
+	if len(l.buf) > 0 {
+		oldest = l.buf[0].Seq
+	}
+	for _, m := range l.buf {
+		if m.Seq > seq {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, seq+1 < oldest
+}
+
+// publish numbers m if it belongs to a job, stores it and sends it to
+// the interested subscribers without blocking.
+func (b *broadcaster) publish(m Message) Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if m.JobID != "" {
+		l := b.log(m.JobID)
+		l.seq++
+		m.Seq = l.seq
+		size := b.size
+		if size <= 0 {
+			size = DefaultReplayBytes
+		}
+		l.add(m, size)
+	}
+	for sub := range b.subs {
+		if sub.job != "" && sub.job != m.JobID {
+			continue
+		}
+		select {
+		case sub.ch <- m:
+		default:
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+	return m
+}
+
+// log returns the log of the given job, creating it and discarding the
+// oldest log if necessary. The caller must hold b.mu.
+func (b *broadcaster) log(job string) *jobLog {
+	if l, ok := b.logs[job]; ok {
+		return l
+	}
+	if b.logs == nil {
+		b.logs = make(map[string]*jobLog)
+	}
+	if len(b.order) >= replayJobs {
+		delete(b.logs, b.order[0])
+		b.order = b.order[1:]
+	}
+	l := new(jobLog)
+	b.logs[job] = l
+	b.order = append(b.order, job)
+	return l
+}
+
+// subscribe registers a subscriber to the messages of job, or of all jobs
+// if job is empty. For a single job, it also returns the stored messages
+// with a sequence number greater than since, and whether some of the
+// messages after since are no longer available.
+func (b *broadcaster) subscribe(job string, since int64) (sub *subscriber, backlog []Message, lost bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok := b.logs[job]; ok && job != "" {
+		backlog, lost = l.since(since)
+	}
+	sub = &subscriber{job: job, ch: make(chan Message, subscriberBuffer)}
+	if b.subs == nil {
+		b.subs = make(map[*subscriber]struct{})
+	}
+	b.subs[sub] = struct{}{}
+	return sub, backlog, lost
+}
+
+func (b *broadcaster) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// subscribe subscribes to the messages of job, or of all jobs if job is
+// empty, and returns the stored messages after since. If some of these
+// messages are no longer available, the backlog starts with an error
+// message saying so. If the job has finished and its messages have been
+// discarded, the backlog ends with a job-finished message made from the
+// history, and done is true because no more messages will follow.
+func (s *Server) subscribe(job string, since int64) (sub *subscriber, backlog []Message, done bool) {
+	sub, backlog, lost := s.broadcaster.subscribe(job, since)
+	if lost {
+		backlog = append([]Message{{
+			Version: ProtocolVersion,
+			Type:    MessageError,
+			JobID:   job,
+			Time:    time.Now(),
+			Error:   "some earlier messages are no longer available",
+		}}, backlog...)
+	}
+	if job == "" {
+		return sub, backlog, false
+	}
+	for _, m := range backlog {
+		if m.Type == MessageJobFinished {
+			return sub, backlog, false
+		}
+	}
+	// Jobs are saved in the history with their final status before they
+	// are removed from s.jobs, so a job that is not there has finished.
+	if _, ok := s.jobs.get(job); ok {
+		return sub, backlog, false
+	}
+	j, err := s.JobStore.Get(job)
+	if err != nil {
+		return sub, backlog, false
+	}
+	backlog = append(backlog, Message{
+		Version: ProtocolVersion,
+		Type:    MessageJobFinished,
+		JobID:   j.ID,
+		Time:    j.End,
+		Status:  j.Status,
+		Error:   j.Error,
+	})
+	return sub, backlog, true
+}
+
+func heartbeatMessage(t time.Time) Message {
+	return Message{Version: ProtocolVersion, Type: MessageHeartbeat, Time: t}
+}
@@ -0,0 +1,111 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Names of the output streams of a job.
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// stdioMu ensures that only one run at a time redirects the standard
+// streams, which are shared by the whole process.
+var stdioMu sync.Mutex
+
+// streamWriter stores whatever is written to it as output of a job, if
+// job is not nil, and sends it to the websocket clients, labelled with the
+// name of the stream. It never blocks on clients.
+type streamWriter struct {
+	s      *Server
+	job    *job
+	stream string
+}
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	var id string
+	if w.job != nil {
+		id = w.job.ID
+		w.job.output.Write(p)
+	}
+	w.s.send(Message{Type: MessageOutput, JobID: id, Stream: w.stream, Data: string(p)})
+	return len(p), nil
+}
+
+// captureStdio redirects the standard output and standard error of the
+// process to stdout and stderr until the returned function is called.
+// The function blocks until all captured output has been forwarded,
+// unless the streams could not be restored, in which case it returns the
+// error without waiting for output that may never end.
+func captureStdio(stdout, stderr io.Writer) (restore func() error, err error) {
+	stdioMu.Lock()
+	rOut, wOut, err := os.Pipe()
+	if err != nil {
+		stdioMu.Unlock()
+		return nil, err
+	}
+	rErr, wErr, err := os.Pipe()
+	if err != nil {
+		rOut.Close()
+		wOut.Close()
+		stdioMu.Unlock()
+		return nil, err
+	}
+	undo, err := redirectStdio(wOut, wErr)
+	if err != nil {
+		rOut.Close()
+		wOut.Close()
+		rErr.Close()
+		wErr.Close()
+		stdioMu.Unlock()
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	forward := func(w io.Writer, r *os.File) {
+		io.Copy(w, r)
+		r.Close()
+		wg.Done()
+	}
+	go forward(stdout, rOut)
+	go forward(stderr, rErr)
+
+	return func() error {
+		err := undo()
+		wOut.Close()
+		wErr.Close()
+		if err == nil {
+			wg.Wait()
+		}
+		stdioMu.Unlock()
+		return err
+	}, nil
+}
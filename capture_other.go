@@ -0,0 +1,46 @@
+//go:build !unix
+
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"log"
+	"os"
+)
+
+// redirectStdio replaces os.Stdout, os.Stderr and the output of the
+// standard logger with stdout and stderr. Writers that kept a reference to
+// the original os.Stdout or os.Stderr are not captured.
+func redirectStdio(stdout, stderr *os.File) (undo func() error, err error) {
+	savedOut, savedErr, savedLog := os.Stdout, os.Stderr, log.Writer()
+	os.Stdout, os.Stderr = stdout, stderr
+	log.SetOutput(stderr)
+	return func() error {
+		os.Stdout, os.Stderr = savedOut, savedErr
+		log.SetOutput(savedLog)
+		return nil
+	}, nil
+}
@@ -0,0 +1,71 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/gobratest"
+	"github.com/spf13/cobra"
+)
+
+func TestCaptureStdio(t *testing.T) {
+	root := &cobra.Command{Use: "app"}
+	root.AddCommand(&cobra.Command{Use: "print", Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println("to stdout")
+		fmt.Fprintln(os.Stderr, "to stderr")
+		cmd.Println("to the command")
+	}})
+	ts := gobratest.NewServer(t, &gobra.Server{Root: root, CaptureStdio: true})
+	ws := ts.Websocket("")
+
+	r := ts.Run("app/print", nil).ExpectStatus(gobra.JobSucceeded)
+	for _, want := range []string{"to stdout", "to stderr", "to the command"} {
+		if !strings.Contains(r.Output, want) {
+			t.Errorf("output %q does not contain %q", r.Output, want)
+		}
+	}
+	ws.Output(r.Job.ID)
+	streams := make(map[string]string)
+	for _, m := range ws.Messages() {
+		if m.JobID == r.Job.ID && m.Type == gobra.MessageOutput {
+			streams[m.Stream] += m.Data
+		}
+	}
+	if !strings.Contains(streams["stdout"], "to stdout") || !strings.Contains(streams["stderr"], "to stderr") {
+		t.Errorf("streams = %q", streams)
+	}
+
+	// The standard streams are restored afterwards.
+	ts.Gobra.CaptureStdio = false
+	r = ts.Run("app/print", nil).ExpectStatus(gobra.JobSucceeded)
+	if strings.Contains(r.Output, "to stdout") {
+		t.Errorf("output %q captured without CaptureStdio", r.Output)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// redirectStdio points the standard output and standard error file
+// descriptors of the process to stdout and stderr. Because the descriptors
+// themselves are replaced, this also captures writers that hold on to the
+// original os.Stdout and os.Stderr, such as loggers created at start-up.
+func redirectStdio(stdout, stderr *os.File) (undo func() error, err error) {
+	savedOut, err := unix.Dup(int(os.Stdout.Fd()))
+	if err != nil {
+		return nil, err
+	}
+	savedErr, err := unix.Dup(int(os.Stderr.Fd()))
+	if err != nil {
+		unix.Close(savedOut)
+		return nil, err
+	}
+	if err = unix.Dup2(int(stdout.Fd()), int(os.Stdout.Fd())); err == nil {
+		err = unix.Dup2(int(stderr.Fd()), int(os.Stderr.Fd()))
+	}
+	undo = func() error {
+		errOut := unix.Dup2(savedOut, int(os.Stdout.Fd()))
+		errErr := unix.Dup2(savedErr, int(os.Stderr.Fd()))
+		unix.Close(savedOut)
+		unix.Close(savedErr)
+		if errOut != nil {
+			return fmt.Errorf("restoring standard output: %v", errOut)
+		}
+		if errErr != nil {
+			return fmt.Errorf("restoring standard error: %v", errErr)
+		}
+		return nil
+	}
+	if err != nil {
+		undo()
+		return nil, err
+	}
+	return undo, nil
+}
@@ -0,0 +1,543 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package client runs the commands of a gobra server over its HTTP API.
+//
+//	c := client.New("http://localhost:8080")
+//	job, err := c.Run(ctx, client.Request{
+//		Commands: []string{"root", "sub"},
+//		Flags:    map[string]interface{}{"count": 3, "names": []string{"a", "b"}},
+//		Files:    map[string][]string{"input": {"data.csv"}},
+//	}, os.Stdout, os.Stderr)
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctessum/gobra/api"
+)
+
+// reconnectDelay is how long Stream waits before reconnecting to the
+// event stream of a job after the connection is lost.
+const reconnectDelay = time.Second
+
+// Client calls the API of a gobra server. Its methods may be called
+// concurrently.
+type Client struct {
+	// URL is the base URL of the server, such as "http://localhost:8080".
+	URL string
+
+	// HTTPClient is used to make requests. When nil, http.DefaultClient
+	// is used.
+	HTTPClient *http.Client
+
+	// Header holds headers added to every request, such as Authorization.
+	Header http.Header
+
+	mu     sync.Mutex
+	schema *api.CommandSchema
+}
+
+// New returns a client of the server at the given URL.
+func New(url string) *Client {
+	return &Client{URL: url}
+}
+
+// Request describes a command to run.
+type Request struct {
+	// Commands is the command path, starting with the root command name.
+	Commands []string
+
+	// Flags are the flag values. Values are marshaled to JSON, so they
+	// must have the type of the flag: bool for bool flags, numbers for
+	// numeric flags, slices for slice flags and strings otherwise.
+	Flags map[string]interface{}
+
+	// Args are the positional arguments of the command.
+	Args []string
+
+	// Files are local files to upload for uploadable flags, by flag name.
+	// Flags that do not take a slice must be given a single file.
+	Files map[string][]string
+}
+
+// Error is returned for requests the server responds to with an error.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("gobra: server responded with %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// JobError is returned by Run for jobs that did not succeed.
+type JobError struct {
+	Job *api.Job
+}
+
+func (e *JobError) Error() string {
+	if e.Job.Error != "" {
+		return fmt.Sprintf("gobra: job %s %s: %s", e.Job.ID, e.Job.Status, e.Job.Error)
+	}
+	return fmt.Sprintf("gobra: job %s %s", e.Job.ID, e.Job.Status)
+}
+
+// Schema returns the command tree of the server. It is fetched once and
+// then cached.
+func (c *Client) Schema(ctx context.Context) (*api.CommandSchema, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.schema != nil {
+		return c.schema, nil
+	}
+	var schema api.CommandSchema
+	if err := c.getJSON(ctx, "/schema", &schema); err != nil {
+		return nil, err
+	}
+	c.schema = &schema
+	return c.schema, nil
+}
+
+// Flags returns the flags accepted by the command with the given path,
+// including the persistent flags of its parents.
+func Flags(schema *api.CommandSchema, commands []string) ([]api.FlagSchema, error) {
+	if len(commands) == 0 || commands[0] != schema.Name {
+		return nil, fmt.Errorf("gobra: command path must start with %q", schema.Name)
+	}
+	var flags []api.FlagSchema
+	cs := schema
+	for i := 0; ; i++ {
+		for _, f := range cs.Flags {
+			if f.Persistent || i == len(commands)-1 {
+				flags = append(flags, f)
+			}
+		}
+		if i == len(commands)-1 {
+			return flags, nil
+		}
+		var next *api.CommandSchema
+		for j := range cs.Commands {
+			if cs.Commands[j].Name == commands[i+1] {
+				next = &cs.Commands[j]
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("gobra: unknown command %q", strings.Join(commands[:i+2], " "))
+		}
+		cs = next
+	}
+}
+
+// Upload uploads the local file at path for the named flag and returns
+// the path of the file on the server, which is the value to pass to the
+// flag.
+func (c *Client) Upload(ctx context.Context, flag, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("gobra: opening file to upload: %v", err)
+	}
+	defer f.Close()
+
+	// The body is streamed so that large files are not held in memory.
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		err := mw.WriteField("name", flag)
+		if err == nil {
+			err = mw.WriteField("type", "string")
+		}
+		if err == nil {
+			var part io.Writer
+			part, err = mw.CreateFormFile("data", filepath.Base(path))
+			if err == nil {
+				_, err = io.Copy(part, f)
+			}
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, err := c.newRequest(ctx, http.MethodPost, "/upload", pr)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	var resp struct {
+		Path string `json:"path"`
+	}
+	if err := c.doJSON(req, http.StatusOK, &resp); err != nil {
+		return "", err
+	}
+	return resp.Path, nil
+}
+
+// Start uploads the files of r and starts the command without waiting
+// for it to finish. The returned job is queued or running.
+func (c *Client) Start(ctx context.Context, r Request) (*api.Job, error) {
+	schema, err := c.Schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	flags, err := Flags(schema, r.Commands)
+	if err != nil {
+		return nil, err
+	}
+	body := api.CommandRequest{
+		Flags: make(map[string]json.RawMessage, len(r.Flags)+len(r.Files)),
+		Args:  r.Args,
+	}
+	for name, v := range r.Flags {
+		if _, ok := findFlag(flags, name); !ok {
+			return nil, fmt.Errorf("gobra: unknown flag %q", name)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("gobra: flag %q: %v", name, err)
+		}
+		body.Flags[name] = b
+	}
+	for name, paths := range r.Files {
+		f, ok := findFlag(flags, name)
+		if !ok {
+			return nil, fmt.Errorf("gobra: unknown flag %q", name)
+		}
+		if !f.Uploadable {
+			return nil, fmt.Errorf("gobra: flag %q does not accept uploads", name)
+		}
+		slice := strings.HasSuffix(f.Type, "Slice") || strings.HasSuffix(f.Type, "Array")
+		if !slice && len(paths) != 1 {
+			return nil, fmt.Errorf("gobra: flag %q takes a single file", name)
+		}
+		uploaded := make([]string, len(paths))
+		for i, p := range paths {
+			if uploaded[i], err = c.Upload(ctx, name, p); err != nil {
+				return nil, err
+			}
+		}
+		var v interface{} = uploaded
+		if !slice {
+			v = uploaded[0]
+		}
+		body.Flags[name], _ = json.Marshal(v)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	path := ""
+	for _, cmd := range r.Commands {
+		path += "/" + url.PathEscape(cmd)
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, path, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Prefer", "respond-async")
+	var job api.Job
+	if err := c.doJSON(req, http.StatusAccepted, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func findFlag(flags []api.FlagSchema, name string) (api.FlagSchema, bool) {
+	for _, f := range flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return api.FlagSchema{}, false
+}
+
+// Run runs the command described by r, writes its output to stdout and
+// stderr as it is produced and returns the finished job. If the job does
+// not succeed, the error is a *JobError.
+func (c *Client) Run(ctx context.Context, r Request, stdout, stderr io.Writer) (*api.Job, error) {
+	job, err := c.Start(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Stream(ctx, job.ID, func(m api.Message) error {
+		if m.Type != api.MessageOutput {
+			return nil
+		}
+		w := stdout
+		if m.Stream == "stderr" {
+			w = stderr
+		}
+		if w == nil {
+			return nil
+		}
+		_, err := io.WriteString(w, m.Data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if job, err = c.Job(ctx, job.ID); err != nil {
+		return nil, err
+	}
+	if job.Status != api.JobSucceeded {
+		return job, &JobError{Job: job}
+	}
+	return job, nil
+}
+
+// Wait waits for the job with the given ID to finish and returns it.
+func (c *Client) Wait(ctx context.Context, id string) (*api.Job, error) {
+	if err := c.Stream(ctx, id, func(api.Message) error { return nil }); err != nil {
+		return nil, err
+	}
+	return c.Job(ctx, id)
+}
+
+// Stream calls fn with each message of the job with the given ID until
+// the job finishes, reconnecting if the connection is lost. It returns
+// the first error returned by fn. For a job that has already finished,
+// fn is called once with the job's whole output as a stdout message.
+func (c *Client) Stream(ctx context.Context, id string, fn func(api.Message) error) error {
+	job, err := c.Job(ctx, id)
+	if err != nil {
+		return err
+	}
+	if finished(job.Status) {
+		var out bytes.Buffer
+		if err := c.Output(ctx, id, &out); err != nil {
+			return err
+		}
+		if out.Len() > 0 {
+			if err := fn(api.Message{Type: api.MessageOutput, JobID: id, Stream: "stdout", Data: out.String()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var since int64
+	for {
+		done, err := c.streamEvents(ctx, id, &since, fn)
+		if done || ctx.Err() != nil {
+			return err
+		}
+		// The connection was lost. The job may have finished in the
+		// meantime without its messages being available any more.
+		job, jerr := c.Job(ctx, id)
+		if _, ok := jerr.(*Error); ok {
+			return jerr
+		}
+		if jerr == nil && finished(job.Status) {
+			return nil
+		}
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// streamEvents reads the server-sent events of a job, starting after
+// the message with sequence number since, which it keeps up to date.
+// done is true once the job has finished or fn returned an error.
+func (c *Client) streamEvents(ctx context.Context, id string, since *int64, fn func(api.Message) error) (done bool, err error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id)+"/events?since="+strconv.FormatInt(*since, 10), nil)
+	if err != nil {
+		return true, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return true, responseError(resp)
+	}
+
+	br := bufio.NewReader(resp.Body)
+	var data []byte
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return false, err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case len(line) == 0 && len(data) > 0:
+			var m api.Message
+			if err := json.Unmarshal(data, &m); err != nil {
+				return true, fmt.Errorf("gobra: decoding event: %v", err)
+			}
+			data = data[:0]
+			if m.Seq > 0 {
+				*since = m.Seq
+			}
+			if err := fn(m); err != nil {
+				return true, err
+			}
+			if m.Type == api.MessageJobFinished {
+				return true, nil
+			}
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimPrefix(bytes.TrimPrefix(line, []byte("data:")), []byte(" "))...)
+		}
+	}
+}
+
+func finished(status api.JobStatus) bool {
+	return status != api.JobQueued && status != api.JobRunning
+}
+
+// Job returns the job with the given ID.
+func (c *Client) Job(ctx context.Context, id string) (*api.Job, error) {
+	var job api.Job
+	if err := c.getJSON(ctx, "/jobs/"+url.PathEscape(id), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Output writes the output of the job with the given ID so far to w.
+func (c *Client) Output(ctx context.Context, id string, w io.Writer) error {
+	return c.download(ctx, "/jobs/"+url.PathEscape(id)+"/output", w)
+}
+
+// Download writes the contents of an output file of a job to w.
+func (c *Client) Download(ctx context.Context, a api.Artifact, w io.Writer) error {
+	return c.download(ctx, a.URL, w)
+}
+
+// Cancel cancels the queued job with the given ID.
+func (c *Client) Cancel(ctx context.Context, id string) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/jobs/"+url.PathEscape(id)+"/cancel", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	return nil
+}
+
+func (c *Client) download(ctx context.Context, path string, w io.Writer) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(c.URL, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v interface{}) error {
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return c.doJSON(req, http.StatusOK, v)
+}
+
+// doJSON makes a request and decodes the response into v, which must
+// have the given status code.
+func (c *Client) doJSON(req *http.Request, code int, v interface{}) error {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		return responseError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("gobra: decoding response: %v", err)
+	}
+	return nil
+}
+
+func responseError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	msg := strings.TrimSpace(string(b))
+	var fe api.FieldError
+	if json.Unmarshal(b, &fe) == nil && fe.Message != "" {
+		msg = fe.Message
+		if fe.Field != "" {
+			msg = fe.Field + ": " + msg
+		}
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: msg}
+}
@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/client"
+	"github.com/ctessum/gobra/gobratest"
+	"github.com/spf13/cobra"
+)
+
+func newServer(t *testing.T) *gobratest.Server {
+	root := &cobra.Command{Use: "app"}
+	root.PersistentFlags().Bool("verbose", false, "")
+	var words []string
+	var in, out string
+	echo := &cobra.Command{Use: "echo", RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return errors.New("nothing to echo")
+		}
+		if v, _ := cmd.Flags().GetBool("verbose"); v {
+			cmd.Print("echo: ")
+		}
+		cmd.Println(words, args)
+		if in != "" {
+			b, err := ioutil.ReadFile(in)
+			if err != nil {
+				return err
+			}
+			cmd.Print(string(b))
+		}
+		return ioutil.WriteFile(out, []byte("written"), 0644)
+	}}
+	echo.Flags().StringSliceVar(&words, "words", nil, "")
+	echo.Flags().StringVar(&in, "in", "", "")
+	echo.Flags().StringVar(&out, "out", "out.txt", "")
+	root.AddCommand(echo)
+	s := &gobra.Server{Root: root}
+	s.MakeFlagUploadable("in")
+	s.MakeFlagOutput("out")
+	return gobratest.NewServer(t, s)
+}
+
+func TestRun(t *testing.T) {
+	ts := newServer(t)
+	var stdout bytes.Buffer
+	job, err := ts.API().Run(context.Background(), client.Request{
+		Commands: []string{"app", "echo"},
+		Flags:    map[string]interface{}{"verbose": true, "words": []string{"a,b", "c"}},
+		Args:     []string{"--x"},
+		Files:    map[string][]string{"in": {filepath.Join("..", "testdata", "input.txt")}},
+	}, &stdout, &stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo: [a,b c] [--x]\nhello from a fixture\n"; stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+	if job.Status != gobra.JobSucceeded || len(job.Artifacts) != 1 {
+		t.Errorf("job = %+v", job)
+	}
+
+	_, err = ts.API().Run(context.Background(), client.Request{Commands: []string{"app", "echo"}}, nil, nil)
+	var je *client.JobError
+	if !errors.As(err, &je) || je.Job.Status != gobra.JobFailed {
+		t.Errorf("error = %v, want a failed job", err)
+	}
+
+	_, err = ts.API().Run(context.Background(), client.Request{
+		Commands: []string{"app", "echo"},
+		Flags:    map[string]interface{}{"verbose": "yes"},
+	}, nil, nil)
+	var e *client.Error
+	if !errors.As(err, &e) || e.Message != "flags.verbose: expected a boolean" {
+		t.Errorf("error = %v, want an invalid flag error", err)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	ts := newServer(t)
+	dir := t.TempDir()
+	cmd, err := ts.API().Command(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"echo", "--words", "a", "--words", "b", "--verbose", "--out", filepath.Join(dir, "out.txt"), "hi"})
+	if err := cmd.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "echo: [a b] [hi]\n"; stdout.String() != want {
+		t.Errorf("output = %q, want %q", stdout.String(), want)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil || string(b) != "written" {
+		t.Errorf("downloaded output file = %q, %v", b, err)
+	}
+
+	// Output files are downloaded into a directory given to the flag,
+	// and not at all if the flag is not set.
+	for _, args := range [][]string{
+		{"echo", "--out", filepath.Join(dir, "sub"), "hi"},
+		{"echo", "hi"},
+	} {
+		if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+		cmd.SetArgs(args)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err = ioutil.ReadFile(filepath.Join(dir, "sub", "out.txt"))
+	if err != nil || string(b) != "written" {
+		t.Errorf("output file downloaded into a directory = %q, %v", b, err)
+	}
+	if _, err := os.Stat("out.txt"); !os.IsNotExist(err) {
+		os.Remove("out.txt")
+		t.Errorf("output file downloaded to the working directory without --out")
+	}
+}
+
+// TestImports checks that the client does not depend on the server
+// package and its dependencies.
+func TestImports(t *testing.T) {
+	for _, dir := range []string{".", filepath.Join("..", "api")} {
+		p, err := build.ImportDir(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range p.Imports {
+			if imp == "github.com/ctessum/gobra" || dir != "." && strings.Contains(strings.Split(imp, "/")[0], ".") {
+				t.Errorf("package %s imports %s", p.Name, imp)
+			}
+		}
+	}
+}
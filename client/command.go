@@ -0,0 +1,254 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/gobra/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Command returns a cobra command tree that mirrors the commands of the
+// server and runs them remotely. Flags are parsed locally, local files
+// given to uploadable flags are uploaded and output is streamed to the
+// command's output. The files written to output flags are downloaded if
+// the flags are set, to the local paths given to them or, if a path is a
+// directory, into it with the file name chosen by the server.
+func (c *Client) Command(ctx context.Context) (*cobra.Command, error) {
+	schema, err := c.Schema(ctx)
+	if err != nil {
+		return nil, err
+	}
+	root := c.command(schema, nil)
+	// Errors come from the server, so the usage would not help.
+	root.SilenceUsage = true
+	return root, nil
+}
+
+func (c *Client) command(cs *api.CommandSchema, parents []string) *cobra.Command {
+	path := append(append([]string(nil), parents...), cs.Name)
+	cmd := &cobra.Command{
+		Use:   cs.Use,
+		Short: cs.Short,
+		Long:  cs.Long,
+	}
+	for _, f := range cs.Flags {
+		fs := cmd.Flags()
+		if f.Persistent {
+			fs = cmd.PersistentFlags()
+		}
+		defineFlag(fs, f)
+	}
+	if cs.Runnable {
+		cmd.Args = cobra.ArbitraryArgs
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			return c.runCommand(cmd, path, args)
+		}
+	}
+	for i := range cs.Commands {
+		cmd.AddCommand(c.command(&cs.Commands[i], path))
+	}
+	return cmd
+}
+
+// defineFlag adds a flag of the type given by the schema to fs, so that
+// its values are checked locally. Flags of types that are not known are
+// taken as strings and checked by the server.
+func defineFlag(fs *pflag.FlagSet, f api.FlagSchema) {
+	name, short, usage := f.Name, f.Shorthand, f.Usage
+	switch f.Type {
+	case "bool":
+		fs.BoolP(name, short, false, usage)
+	case "count":
+		fs.CountP(name, short, usage)
+	case "int":
+		fs.IntP(name, short, 0, usage)
+	case "int8":
+		fs.Int8P(name, short, 0, usage)
+	case "int16":
+		fs.Int16P(name, short, 0, usage)
+	case "int32":
+		fs.Int32P(name, short, 0, usage)
+	case "int64":
+		fs.Int64P(name, short, 0, usage)
+	case "uint":
+		fs.UintP(name, short, 0, usage)
+	case "uint8":
+		fs.Uint8P(name, short, 0, usage)
+	case "uint16":
+		fs.Uint16P(name, short, 0, usage)
+	case "uint32":
+		fs.Uint32P(name, short, 0, usage)
+	case "uint64":
+		fs.Uint64P(name, short, 0, usage)
+	case "float32":
+		fs.Float32P(name, short, 0, usage)
+	case "float64":
+		fs.Float64P(name, short, 0, usage)
+	case "duration":
+		fs.DurationP(name, short, 0, usage)
+	case "boolSlice":
+		fs.BoolSliceP(name, short, nil, usage)
+	case "intSlice":
+		fs.IntSliceP(name, short, nil, usage)
+	case "int32Slice":
+		fs.Int32SliceP(name, short, nil, usage)
+	case "int64Slice":
+		fs.Int64SliceP(name, short, nil, usage)
+	case "uintSlice":
+		fs.UintSliceP(name, short, nil, usage)
+	case "float32Slice":
+		fs.Float32SliceP(name, short, nil, usage)
+	case "float64Slice":
+		fs.Float64SliceP(name, short, nil, usage)
+	case "durationSlice":
+		fs.DurationSliceP(name, short, nil, usage)
+	case "stringArray":
+		fs.StringArrayP(name, short, nil, usage)
+	default:
+		if strings.HasSuffix(f.Type, "Slice") {
+			fs.StringSliceP(name, short, nil, usage)
+		} else {
+			fs.StringP(name, short, "", usage)
+		}
+	}
+	// The default is only shown in the help: flags that are not set
+	// are left to the server.
+	fs.Lookup(name).DefValue = f.Default
+}
+
+// runCommand runs the command with the given path on the server with the
+// flags that were set locally.
+func (c *Client) runCommand(cmd *cobra.Command, path, args []string) error {
+	ctx := cmd.Context()
+	schema, err := c.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	flags, err := Flags(schema, path)
+	if err != nil {
+		return err
+	}
+	r := Request{
+		Commands: path,
+		Flags:    make(map[string]interface{}),
+		Args:     args,
+		Files:    make(map[string][]string),
+	}
+	outputs := make(map[string]string)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		fs, ok := findFlag(flags, f.Name)
+		switch {
+		case !ok:
+		case fs.Output:
+			// Output flags are set by the server, and the local value
+			// is where to download the file to.
+			if f.Changed {
+				outputs[f.Name] = f.Value.String()
+			}
+		case !f.Changed:
+		case fs.Uploadable:
+			if sv, ok := f.Value.(pflag.SliceValue); ok {
+				r.Files[f.Name] = sv.GetSlice()
+			} else if v := f.Value.String(); v != "" {
+				r.Files[f.Name] = []string{v}
+			}
+		default:
+			r.Flags[f.Name] = flagValue(f.Value)
+		}
+	})
+
+	job, err := c.Run(ctx, r, cmd.OutOrStdout(), cmd.ErrOrStderr())
+	if err != nil {
+		return err
+	}
+	for _, a := range job.Artifacts {
+		p, ok := outputs[a.Name]
+		if !ok {
+			continue
+		}
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			// Only the base name is taken from the server, so that it
+			// cannot choose where the file is written.
+			name := filepath.Base(a.Filename)
+			if name == "." || name == ".." || name == string(filepath.Separator) {
+				return fmt.Errorf("gobra: invalid output file name %q", a.Filename)
+			}
+			p = filepath.Join(p, name)
+		}
+		if err := c.downloadFile(ctx, a, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagValue returns the value of a flag with the JSON type expected by
+// the server.
+func flagValue(v pflag.Value) interface{} {
+	if sv, ok := v.(pflag.SliceValue); ok {
+		typ := strings.TrimSuffix(strings.TrimSuffix(v.Type(), "Slice"), "Array")
+		elems := sv.GetSlice()
+		vs := make([]interface{}, len(elems))
+		for i, e := range elems {
+			vs[i] = scalarValue(typ, e)
+		}
+		return vs
+	}
+	return scalarValue(v.Type(), v.String())
+}
+
+func scalarValue(typ, s string) interface{} {
+	switch {
+	case typ == "bool":
+		b, _ := strconv.ParseBool(s)
+		return b
+	case typ == "count" || strings.HasPrefix(typ, "int") || strings.HasPrefix(typ, "uint") || strings.HasPrefix(typ, "float"):
+		return json.Number(s)
+	default:
+		return s
+	}
+}
+
+// downloadFile downloads an output file of a job to the local path p.
+func (c *Client) downloadFile(ctx context.Context, a api.Artifact, p string) error {
+	f, err := os.Create(p)
+	if err != nil {
+		return fmt.Errorf("gobra: creating output file: %v", err)
+	}
+	if err := c.Download(ctx, a, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// CSRFHeader is the request header that carries the token returned by
+// CSRFToken.
+const CSRFHeader = "X-Gobra-CSRF-Token"
+
+var errCrossOrigin = errors.New("cross-origin request without a valid CSRF token")
+
+// CSRFToken returns the token that allows pages from other origins to
+// make requests that change state, such as running commands, when it is
+// sent in the X-Gobra-CSRF-Token header. Render embeds it in the page. It
+// is derived from CSRFKey, so tokens stop being valid when the key
+// changes.
+func (s *Server) CSRFToken() string {
+	mac := hmac.New(sha256.New, s.CSRFKey)
+	mac.Write([]byte("gobra csrf token"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// initCSRFKey generates a random CSRFKey if none is set.
+func (s *Server) initCSRFKey() error {
+	if len(s.CSRFKey) > 0 {
+		return nil
+	}
+	s.CSRFKey = make([]byte, 32)
+	if _, err := rand.Read(s.CSRFKey); err != nil {
+		return fmt.Errorf("gobra: generating CSRF key: %v", err)
+	}
+	return nil
+}
+
+// checkOrigin returns an error if a request that changes state may have
+// been made by a page from another origin on behalf of the user, and does
+// not carry a valid CSRF token. Requests from clients other than browsers,
+// which send neither the Sec-Fetch-Site nor the Origin header, are
+// allowed.
+func (s *Server) checkOrigin(r *http.Request) error {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if token := r.Header.Get(CSRFHeader); token != "" &&
+		hmac.Equal([]byte(token), []byte(s.CSRFToken())) {
+		return nil
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return nil
+	case "":
+	default:
+		return errCrossOrigin
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host {
+		return nil
+	}
+	return errCrossOrigin
+}
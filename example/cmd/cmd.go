@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"time"
 
+	"github.com/ctessum/gobra"
 	"github.com/spf13/cobra"
 )
 
@@ -26,6 +27,9 @@ var (
 	// addition flags
 	num1, num2 int
 
+	// file to write the sum to
+	output string
+
 	// paths of file to print, measure
 	path  string
 	path2 string
@@ -49,6 +53,7 @@ func init() {
 	steadyCmd.Flags().IntVar(&begin, "begin", 0, "Beginning row index.")
 	addition.Flags().IntVar(&num1, "num1", 1, "First number")
 	addition.Flags().IntVar(&num2, "num2", 1, "Second number")
+	addition.Flags().StringVar(&output, "output", "sum.txt", "file to write the sum to")
 	printCmd.Flags().StringVar(&path, "path", "", "filepath to determine length")
 	printCmd.Flags().StringVar(&path2, "path2", "", "file to print")
 	printMultipleCmd.Flags().StringSliceVar(&path3, "path3", []string{""}, "files to print")
@@ -94,7 +99,13 @@ var runCmd = &cobra.Command{
 		cmd.Println("Running program the program.")
 		for i := range make([]int, 10) {
 			cmd.Printf("Output: %d\n", i)
-			time.Sleep(time.Duration(200) * time.Millisecond)
+			gobra.ReportProgress(cmd.Context(), float64(i+1), 10, "counting")
+			select {
+			case <-time.After(time.Duration(200) * time.Millisecond):
+			case <-cmd.Context().Done():
+				// Stop when the job times out.
+				return cmd.Context().Err()
+			}
 		}
 		return nil
 	},
@@ -104,8 +115,9 @@ var addition = &cobra.Command{
 	Use:   "add",
 	Short: "adds two number",
 	Long:  "We perform the addition operation on two numerical operands. The operation yields the sum of two operands, which are inputted as flags.",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.Println(num1 + num2)
+		return ioutil.WriteFile(output, []byte(fmt.Sprintln(num1+num2)), 0644)
 	},
 }
 
@@ -62,7 +62,8 @@ func main() {
 
 	fmt.Println("Starting server at localhost:8080")
 
-	server := gobra.Server{Root: cmd.Root, ServerAddress: "localhost:8080", AllowCORS: true, HTML: output}
+	server := gobra.Server{Root: cmd.Root, ServerAddress: "localhost:8080", AllowCORS: true, HTML: output, CaptureStdio: true}
 	server.MakeFlagUploadable("path", "path2", "path3")
+	server.MakeFlagOutput("output")
 	server.Start()
 }
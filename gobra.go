@@ -34,12 +34,18 @@ import (
 	"html/template"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/robfig/cron/v3"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"golang.org/x/net/websocket"
@@ -92,9 +98,10 @@ const commandTpl = `
 		<p>{{.Long}}</p>
 		<ul class="flags">
 			{{ range (flagSetToSlice .PersistentFlags .LocalNonPersistentFlags) }}
-				<li><code data-name={{ .Name }} data-type={{.Type}}>--{{ .Name }}=<input type="text" value={{ .Value.String }}></input>
+				<li><code data-name={{ .Name }} data-type={{.Type}} {{ if (isSensitiveFlag .Name) }}data-sensitive{{ end }}>--{{ .Name }}={{ if (isOutputFlag .Name) }}<em>output file</em>{{ else if (isSensitiveFlag .Name) }}<input type="password" value="" autocomplete="off"></input>{{ else }}<input type="text" value={{ .Value.String }}></input>{{ end }}
 					{{ if (canUploadFile .Name) }}
-						<input type="file" name="{{ .Name }}" {{ if (isStringSlice .Type) }}multiple{{ end }}>
+						<input type="file" name="{{ .Name }}" {{ if (isStringSlice .Type) }}multiple{{ end }} {{ if (isArchiveFlag .Name) }}accept=".zip,.tar.gz,.tgz"{{ end }}>
+						<progress value="0" style="display:none"></progress>
 					{{ end }}
 					</code><br>
 					<blockquote>{{ .Usage }}</blockquote>
@@ -118,33 +125,219 @@ const commandTpl = `
 {{ template "command" .Root }}
 <br/>
 <button>Execute</button>
+<button class="gobraCancel" style="display:none">Cancel</button>
+<progress class="gobraProgress" style="display:none; width:100%;"></progress>
+<span class="gobraProgressMessage"></span>
 
 <pre class="gobraStatus" style="padding:10px; background:lightgray; height:30em; overflow-y:scroll; white-space: pre-wrap; word-break: break-all;">
 </pre>
+<div class="gobraArtifacts"></div>
+
+<details class="gobraHistory">
+	<summary>History</summary>
+	<table>
+		<thead><tr><th>Started</th><th>Command</th><th>User</th><th>Status</th><th>Duration</th><th></th><th></th></tr></thead>
+		<tbody></tbody>
+	</table>
+</details>
+
+<details class="gobraSchedules">
+	<summary>Schedules</summary>
+	<table>
+		<thead><tr><th>Schedule</th><th>Command</th><th>Next run</th><th>Last run</th><th></th></tr></thead>
+		<tbody></tbody>
+	</table>
+</details>
 
 <script>
 const serverAddress = {{ if .ServerAddress}} "{{ .ServerAddress }}" {{ else }} "" {{ end }};
 
+// protocolVersion is the version of the messages sent by the server.
+const protocolVersion = {{ protocolVersion }};
+
+// csrfHeaders are sent with every request that changes something on the
+// server, to show that it comes from this page.
+const csrfHeaders = {"{{ csrfHeader }}": "{{ csrfToken }}"};
+
 {{ with .Root }}
 const logger = document.querySelector("#gobra-{{.Use}} .gobraStatus");
 const execBtn = document.querySelector("#gobra-{{.Use}}>button");
+const cancelBtn = document.querySelector("#gobra-{{.Use}} .gobraCancel");
+const artifacts = document.querySelector("#gobra-{{.Use}} .gobraArtifacts");
+const history = document.querySelector("#gobra-{{.Use}} .gobraHistory tbody");
+const schedules = document.querySelector("#gobra-{{.Use}} .gobraSchedules tbody");
+
+// sensitiveFlags holds the names of the flags whose values are not shown.
+const sensitiveFlags = new Set([...document.querySelectorAll("#gobra-{{.Use}} code[data-sensitive]")]
+	.map(code => code.dataset.name));
+const progressBar = document.querySelector("#gobra-{{.Use}} .gobraProgress");
+const progressMessage = document.querySelector("#gobra-{{.Use}} .gobraProgressMessage");
 
 // printData prints appends data to destination and scrolls to bottom.
-const printData = (dest, str) => {
-	dest.textContent += str;
+// Output written to standard error is highlighted.
+const printData = (dest, str, stream) => {
+	if (stream == "stderr") {
+		let span = document.createElement("span");
+		span.className = "gobraStderr";
+		span.style.color = "firebrick";
+		span.textContent = str;
+		dest.appendChild(span);
+	} else {
+		dest.appendChild(document.createTextNode(str));
+	}
 	dest.scrollTop = dest.scrollHeight;
 }
 
+// showProgress displays the progress reported by a command,
+// or hides the progress bar if progress is null.
+const showProgress = (progress) => {
+	progressBar.style.display = progress ? "" : "none";
+	progressMessage.textContent = progress && progress.message ? progress.message : "";
+	if (progress) {
+		progressBar.max = progress.total;
+		progressBar.value = progress.done;
+	}
+}
+
+// formatCommand formats the command path and flags of a job
+// as they would be typed on the command line.
+const formatCommand = (job) => {
+	let flags = Object.entries(job.flags || {}).map(([k, v]) => "--" + k + "=\"" + v[0] + "\"");
+	let args = (job.args || []).map(a => "\"" + a + "\"");
+	return [...job.commands, ...flags, ...args].join(" ");
+}
+
+// loadJob fills in the form with the commands and flags of a job,
+// so that it can be changed before it is executed again.
+const loadJob = (job) => {
+	const flags = job.flags || {};
+	let el = document.querySelector("#gobra-{{.Use}} > [data-gobra-name]");
+	job.commands.forEach((name, i) => {
+		if (!el) return;
+		el.querySelectorAll(":scope > ul.flags code").forEach(code => {
+			const input = code.querySelector("input:not([type=file])");
+			if (!input) return;
+			// The values of sensitive flags are not kept.
+			input.value = flags[code.dataset.name] && !("sensitive" in code.dataset) ?
+				flags[code.dataset.name][0] : input.defaultValue;
+			input.disabled = false;
+			const file = code.querySelector("input[type=file]");
+			if (file) file.value = "";
+		});
+		const next = job.commands[i+1];
+		const select = el.querySelector(":scope > select[data-gobra-select]");
+		if (select) {
+			if (next) {
+				select.value = next;
+			} else {
+				select.selectedIndex = 0;
+			}
+			select.dispatchEvent(new Event("change"));
+		}
+		el = next ? [...el.children].find(child => child.dataset.gobraName == next) : null;
+	});
+}
+
+// loadHistory shows the most recent jobs.
+const loadHistory = () => {
+	fetch("http://" + serverAddress + "/jobs?limit=20")
+	.then(rejectUnlessOK)
+	.then(jobs => {
+		history.textContent = "";
+		for (const job of jobs) {
+			let row = history.insertRow();
+			const end = new Date(job.end);
+			const duration = job.status == "queued" || job.status == "running" ? "" : ((end - new Date(job.start)) / 1000).toFixed(1) + " s";
+			[new Date(job.start).toLocaleString(), formatCommand(job), job.user || "", job.status, duration]
+				.forEach(text => row.insertCell().textContent = text);
+			let link = document.createElement("a");
+			link.href = "http://" + serverAddress + "/jobs/" + job.id + "/output";
+			link.target = "_blank";
+			link.textContent = "output";
+			row.insertCell().appendChild(link);
+			let actions = row.insertCell();
+			[["Re-run", () => rerunJob(job)], ["Edit", () => loadJob(job)]].forEach(([text, onclick]) => {
+				let btn = document.createElement("button");
+				btn.textContent = text;
+				btn.onclick = onclick;
+				actions.appendChild(btn);
+			});
+		}
+	})
+	.catch(err => printData(logger, "⤬ Failed loading history: " + err + "\n"));
+}
+
+// formatTime formats a time sent by the server, which is empty if it
+// is the zero time.
+const formatTime = (t) => new Date(t).getFullYear() > 1 ? new Date(t).toLocaleString() : "";
+
+// loadSchedules shows the scheduled runs of commands.
+const loadSchedules = () => {
+	fetch("http://" + serverAddress + "/schedules")
+	.then(rejectUnlessOK)
+	.then(list => {
+		schedules.textContent = "";
+		for (const sc of list) {
+			let row = schedules.insertRow();
+			[sc.spec, formatCommand(sc), sc.paused ? "paused" : formatTime(sc.next), formatTime(sc.lastRun)]
+				.forEach(text => row.insertCell().textContent = text);
+			let actions = row.insertCell();
+			[
+				[sc.paused ? "Resume" : "Pause", "POST", sc.paused ? "/resume" : "/pause"],
+				["Delete", "DELETE", ""],
+			].forEach(([text, method, action]) => {
+				let btn = document.createElement("button");
+				btn.textContent = text;
+				btn.onclick = () => fetch("http://" + serverAddress + "/schedules/" + sc.id + action, {method: method, headers: csrfHeaders})
+					.then(res => res.ok || res.text().then(t => Promise.reject(t)))
+					.then(loadSchedules)
+					.catch(err => printData(logger, "⤬ Failed updating schedule: " + err + "\n"));
+				actions.appendChild(btn);
+			});
+		}
+	})
+	.catch(err => printData(logger, "⤬ Failed loading schedules: " + err + "\n"));
+}
+
 // clearLogger clears content of an output logger.
 const clearLogger = (dest) => {
 	dest.textContent = "";
 }
 
-// serverSend sends a request to the server and returns a Promise.
+// serverSend sends a request to the server to start a job and returns
+// a Promise of the job.
 // It takes in the commands and flags as an array
 // where each flag are of the format "name=value".
 const serverSend = (cmds, flags) => {
-	return fetch("http://"+serverAddress+"/"+cmds.join("/")+"?"+flags.join("&"));
+	return fetch("http://"+serverAddress+"/"+cmds.join("/"), {
+		method: "POST",
+		headers: Object.assign({
+			"Accept": "application/json",
+			"Prefer": "respond-async",
+			"Content-Type": "application/x-www-form-urlencoded",
+		}, csrfHeaders),
+		body: flags.join("&"),
+	})
+	.then(jobResponse);
+}
+
+// jobResponse returns a Promise of the job that a response starts,
+// or of an object holding the error if it failed.
+const jobResponse = (res) => (res.headers.get("Content-Type") || "").startsWith("application/json") ?
+	res.json() : res.text().then(t => ({error: t}));
+
+// showArtifacts adds a download button for each output file of a job.
+const showArtifacts = (job) => {
+	artifacts.textContent = "";
+	for (const a of job.artifacts || []) {
+		let link = document.createElement("a");
+		link.href = "http://" + serverAddress + a.url;
+		link.download = a.filename;
+		let btn = document.createElement("button");
+		btn.textContent = "Download " + a.name + " (" + a.filename + ")";
+		link.appendChild(btn);
+		artifacts.appendChild(link);
+	}
 }
 
 // When an option is chosen, display the correct sub-command.
@@ -156,6 +349,60 @@ document.querySelectorAll("#gobra-{{.Use}} [data-gobra-select]").forEach( option
 		)
 );
 
+// chunkSize is the number of bytes sent per request when uploading files.
+const chunkSize = 8 << 20;
+
+// maxRetries is the number of times a failed chunk is retried before
+// the upload is abandoned.
+const maxRetries = 5;
+
+// rejectUnlessOK returns the JSON body of a response, or a rejected Promise
+// with the response text if the request failed.
+const rejectUnlessOK = res => res.ok ? res.json() : res.text().then(t => Promise.reject(t));
+
+// uploadFile uploads data using the resumable upload protocol, calling
+// onProgress with the number of bytes stored by the server so far.
+// It returns a Promise of the stored file path.
+const uploadFile = (name, type, data, onProgress) => {
+	const base = "http://" + serverAddress + "/upload/chunked";
+	let form = new FormData();
+	form.set("name", name);
+	form.set("type", type);
+	form.set("filename", data.name);
+	form.set("size", data.size);
+
+	return fetch(base, {method: "POST", headers: csrfHeaders, body: form})
+	.then(rejectUnlessOK)
+	.then(upload => {
+		let retries = 0;
+		const send = status => {
+			onProgress(status.offset);
+			if (status.path !== undefined) return status.path;
+			return fetch(base + "/" + upload.id, {
+				method: "PUT",
+				headers: Object.assign({"Upload-Offset": status.offset}, csrfHeaders),
+				body: data.slice(status.offset, status.offset + chunkSize),
+			})
+			.then(rejectUnlessOK)
+			.then(status => {
+				retries = 0;
+				return send(status);
+			}, err => {
+				if (++retries > maxRetries) return Promise.reject(err);
+				// Ask the server how much it has stored and resume from there.
+				return new Promise(resolve => setTimeout(resolve, 1000 * retries))
+					.then(() => fetch(base + "/" + upload.id))
+					.then(rejectUnlessOK)
+					.then(send);
+			});
+		};
+		return send(upload);
+	});
+}
+
+// toCSVField quotes a value for use in a string slice flag.
+const toCSVField = v => /[",\n]/.test(v) ? '"' + v.replace(/"/g, '""') + '"' : v;
+
 let files = document.querySelectorAll("#gobra-{{.Use}} input[type^=f]");
 for (const file of files) {
 	file.addEventListener("change", e => {
@@ -164,10 +411,38 @@ for (const file of files) {
 	})
 }
 
+// followJob displays the output of a job as it runs and the result
+// once it has finished. It takes a Promise of the job.
+const followJob = (request) => request
+	.then( job => job.id ? watchJob(job.id) : job)
+	.then( job => {
+		printData(logger,"← " + (job.error || "Finished. ") + "\n");
+		showArtifacts(job);
+		loadHistory();
+		execBtn.removeAttribute("disabled");
+	})
+	.catch(e => {
+		printData(logger,"⤬ Failed communicating with server: " + e + "\n");
+		execBtn.removeAttribute("disabled");
+	});
+
+// rerunJob starts a new job with the same commands and flags as job.
+const rerunJob = (job) => {
+	execBtn.setAttribute("disabled", "disabled");
+	clearLogger(logger);
+	showArtifacts({});
+	printData(logger, "→ " + formatCommand(job) + "\n");
+	followJob(fetch("http://" + serverAddress + "/jobs/" + job.id + "/rerun", {
+		method: "POST",
+		headers: Object.assign({"Accept": "application/json", "Prefer": "respond-async"}, csrfHeaders),
+	}).then(jobResponse));
+}
+
 // Compile query when Execute is clicked
 execBtn.onclick = e => {
 	execBtn.setAttribute("disabled", "disabled");
 	clearLogger(logger);
+	showArtifacts({});
 
 	// find file inputs and upload them
 	let promisesOfFiles = [];
@@ -178,25 +453,30 @@ execBtn.onclick = e => {
 
 		if (file.files.length === 0) continue;
 
-		let formData = new FormData();
-		formData.set("name", file.parentElement.dataset.name);
-		formData.set("type", file.parentElement.dataset.type);
-		for (const fileData of file.files) {
-			formData.append("data", fileData);
-		}
+		const progress = file.nextElementSibling;
+		const total = [...file.files].reduce((sum, f) => sum + f.size, 0);
+		let loaded = new Array(file.files.length).fill(0);
+		progress.max = total || 1;
+		progress.value = 0;
+		progress.style.display = "";
+
+		let uploads = [...file.files].map((fileData, i) =>
+			uploadFile(file.parentElement.dataset.name, file.parentElement.dataset.type, fileData, n => {
+				loaded[i] = n;
+				progress.value = loaded.reduce((x, y) => x + y, 0);
+			})
+		);
 
-		let request = fetch("http://" + serverAddress + "/upload", {
-			method: "POST",
-			body: formData
-		})
+		let request = Promise.all(uploads)
 		.catch(err => {
 			return Promise.reject("Failed uploading: " + err + "\n");
 		})
-		.then(res => res.json())
-		.then(res => {
-			file.previousElementSibling.value = res.path;
+		.then(paths => {
+			file.previousElementSibling.value = file.parentElement.dataset.type == "stringSlice" ?
+				paths.map(toCSVField).join(",") : paths[0];
 			file.previousElementSibling.disabled = false;
 			file.value= '';
+			progress.style.display = "none";
 		})
 		.catch(err => {
 			return Promise.reject("Failed processing file: " + err + "\n");
@@ -216,7 +496,9 @@ execBtn.onclick = e => {
 				if (el.dataset.gobraName) {
 					cmds.push(el.dataset.gobraName);
 					[...el.querySelector("ul.flags").querySelectorAll("code")].forEach(f => {
-						if(f.children[0]) flags.push(f.dataset.name + "=" + encodeURIComponent(f.children[0].value));
+						if(f.children[0] && f.children[0].tagName == "INPUT" &&
+							// Leave sensitive flags at their default unless a value is entered.
+							!("sensitive" in f.dataset && f.children[0].value === "")) flags.push(f.dataset.name + "=" + encodeURIComponent(f.children[0].value));
 					})
 				}
 				[...el.children].forEach( child => {
@@ -236,19 +518,12 @@ execBtn.onclick = e => {
 		printData(logger, "→ "+resultCmd.reduce((x,y) => {
 				return x.join(" ") + " "
 					+ y.map(z =>
-						"--" + z.split("=")[0] + "=\"" + decodeURIComponent(z.split("=")[1]) + "\""
+						"--" + z.split("=")[0] + "=\"" + (sensitiveFlags.has(z.split("=")[0]) ?
+							"********" : decodeURIComponent(z.split("=")[1])) + "\""
 					).join(" ")
 			})+ "\n");
 
-		serverSend(resultCmd[0], resultCmd[1])
-			.then(res => res.text()).then( d => {
-				printData(logger,"← " + d + "\n");
-				execBtn.removeAttribute("disabled");
-			})
-			.catch(e => {
-				printData(logger,"⤬ Failed communicating with server: " + e + "\n");
-				execBtn.removeAttribute("disabled");
-			});
+		followJob(serverSend(resultCmd[0], resultCmd[1]));
 	})
 	.catch( err => {
 		printData(logger, "⤬ Failed data uploading, command not executed. " + err);
@@ -260,22 +535,107 @@ execBtn.onclick = e => {
 }
 {{ end }}
 
-window.onload = () => {
-	let sock = new WebSocket("ws://" + serverAddress + "/ws");
-
-	sock.onopen = () => {
-		printData(logger, "* Connected.");
+// handleMessage displays a message received from the server.
+const handleMessage = (msg) => {
+	switch (msg.type) {
+	case "output":
+		printData(logger, msg.data, msg.stream);
+		break;
+	case "progress":
+		showProgress(msg.progress);
+		break;
+	case "error":
+		printData(logger, "⤬ " + msg.error + "\n", "stderr");
+		break;
+	case "job-queued":
+		printData(logger, "* Waiting in queue at position " + msg.position + ".\n");
+		cancelBtn.style.display = "";
+		cancelBtn.onclick = () => fetch("http://" + serverAddress + "/jobs/" + msg.job + "/cancel", {method: "POST", headers: csrfHeaders})
+			.then(res => res.ok || res.text().then(t => Promise.reject(t)))
+			.catch(err => printData(logger, "⤬ Failed canceling job: " + err + "\n"));
+		break;
+	case "job-started":
+		cancelBtn.style.display = "none";
+		break;
+	case "job-finished":
+		cancelBtn.style.display = "none";
+		showProgress(null);
+		break;
 	}
+}
 
-	sock.onclose = (e) => {
-		printData(logger, "* Connection Closed. ", e.reason);
-		alert("Lost connection with server");
-	}
+// watchJob displays the messages of a job as they arrive over the
+// websocket. If the connection drops, it reconnects and replays the
+// messages it missed. It returns a Promise of the finished job.
+const watchJob = (id) => new Promise((resolve, reject) => {
+	let lastSeq = 0,
+		retries = 0,
+		finished = false,
+		// useEvents is set if websockets are not available, for example
+		// because a proxy strips the upgrade, to use server-sent events.
+		useEvents = typeof WebSocket === "undefined";
+	const connect = () => {
+		let opened = false,
+			conn;
+
+		const onopen = () => {
+			opened = true;
+			retries = 0;
+		}
 
-	sock.onmessage = (e) => {
-		printData(logger, e.data)
-	}
-}
+		const onclose = () => {
+			if (finished) return;
+			if (!opened && !useEvents) {
+				useEvents = true;
+				connect();
+				return;
+			}
+			if (++retries > maxRetries) {
+				reject("lost connection with server");
+				return;
+			}
+			printData(logger, "* Connection lost, reconnecting.\n");
+			setTimeout(connect, 1000 * retries);
+		}
+
+		const onmessage = (e) => {
+			const msg = JSON.parse(e.data);
+			if (msg.v !== protocolVersion) return;
+			if (msg.seq) lastSeq = msg.seq;
+			handleMessage(msg);
+			if (msg.type == "job-finished") {
+				finished = true;
+				conn.close();
+				fetch("http://" + serverAddress + "/jobs/" + id)
+					.then(rejectUnlessOK)
+					.then(resolve, reject);
+			}
+		}
+
+		if (!useEvents) {
+			try {
+				conn = new WebSocket("ws://" + serverAddress + "/ws?job=" + id + "&since=" + lastSeq);
+				conn.onclose = onclose;
+			} catch (e) {
+				useEvents = true;
+			}
+		}
+		if (useEvents) {
+			conn = new EventSource("http://" + serverAddress + "/jobs/" + id + "/events?since=" + lastSeq);
+			// Reconnect ourselves so that the replay starts at lastSeq.
+			conn.onerror = () => {
+				conn.close();
+				onclose();
+			}
+		}
+		conn.onopen = onopen;
+		conn.onmessage = onmessage;
+	};
+	connect();
+});
+
+loadHistory();
+loadSchedules();
 </script>
 </div>
 `
@@ -310,20 +670,227 @@ type Server struct {
 	// If this is not nil, it will be served as an HTML front end.
 	HTML *template.Template
 
-	// socketChannel is a channel to the websocket handler.
-	// Whatever gets sent to this channel will be sent by the websocket.
-	socketChannel chan string
+	// broadcaster sends messages to the websocket handlers.
+	broadcaster broadcaster
+
+	// OutputPolicy and OutputLimit decide how much of the output of each
+	// job is kept for clients that retrieve it from /jobs/<id>/output
+	// rather than following the job over the websocket. If OutputLimit is
+	// zero, DefaultOutputLimit is used.
+	OutputPolicy OutputPolicy
+	OutputLimit  int
+
+	// StreamResponses, if true, makes plain-text command requests stream
+	// the output of the command as it is produced, ending with a line
+	// that gives the result of the command.
+	StreamResponses bool
+
+	// ReplayBytes is roughly how many bytes of messages are kept for each
+	// of the most recent jobs so that websocket clients can reconnect
+	// without missing output. If it is zero, DefaultReplayBytes is used.
+	ReplayBytes int
+
+	// CaptureStdio, if true, redirects the standard output and standard
+	// error of the process to the websocket while a command runs, so that
+	// output written with fmt.Println, the log package or other loggers
+	// reaches the client, labelled with the stream it was written to.
+	// Because these streams are shared by the whole process, runs with
+	// CaptureStdio are executed one at a time, and anything written to
+	// them by other goroutines during a run is attributed to that run.
+	CaptureStdio bool
 
 	// FileUploadFunc is a function that stores uploaded files and returns the
 	// stored location. The default FileUploadFunc saves files in a temporary
 	// directory.
 	FileUploadFunc func(data io.Reader, name string) (filename string, err error)
 
+	// MaxUploadSize limits the size of each uploaded file, in bytes. If it
+	// is zero, DefaultMaxUploadSize is used.
+	MaxUploadSize int64
+
 	tCmd *template.Template
 
+	// commandPaths maps the paths of the commands in Root, separated by
+	// slashes and with any aliases, to the paths with the command names.
+	// It is built once at start-up because looking up commands in Root
+	// modifies it, which would race with running commands.
+	commandPaths map[string][]string
+
+	// commandFlags maps the paths of the commands in Root, separated by
+	// slashes and with the command names, to the types of the flags they
+	// accept, for the same reason.
+	commandFlags map[string]map[string]flagInfo
+
+	// schema is the schema of Root, which is also computed at start-up
+	// because listing the flags of a command modifies it.
+	schema *CommandSchema
+
 	// uploadableFlags is a set of flag names that can accept file uploads.
 	uploadableFlags map[string]struct{}
 
+	// archiveFlags is a set of flag names whose uploads are archives that
+	// are extracted into a directory.
+	archiveFlags map[string]struct{}
+
+	// ArchiveDir is the directory under which uploaded archives are
+	// extracted, each into its own subdirectory. If empty, the system
+	// temporary directory is used.
+	ArchiveDir string
+
+	// MaxArchiveSize and MaxArchiveFiles limit the total number of bytes
+	// and the number of entries extracted from a single uploaded archive.
+	// If they are zero, DefaultMaxArchiveSize and DefaultMaxArchiveFiles
+	// are used.
+	MaxArchiveSize  int64
+	MaxArchiveFiles int
+
+	// sensitiveFlags is a set of flag names whose values are secret.
+	sensitiveFlags map[string]struct{}
+
+	// outputFlags is a set of flag names that are output files.
+	outputFlags map[string]struct{}
+
+	// OutputDir is the directory under which a directory is created for
+	// the output files of each job. If empty, the system temporary
+	// directory is used.
+	OutputDir string
+
+	// jobs holds the jobs that are running.
+	jobs jobList
+
+	// JobStore keeps the history of jobs. If it is nil, a BoltStore is
+	// opened at HistoryFile so that the history survives restarts, or the
+	// history is kept in a MemoryStore if HistoryFile is empty.
+	JobStore    JobStore
+	HistoryFile string
+
+	// HistoryLimit is the number of finished jobs kept in the history,
+	// and HistoryMaxAge, if not zero, is how long they are kept for. Older
+	// jobs are deleted with their output files. If HistoryLimit is zero,
+	// DefaultHistoryLimit is used.
+	HistoryLimit  int
+	HistoryMaxAge time.Duration
+
+	// lastPrune is when old jobs were last deleted from the history.
+	lastPrune   time.Time
+	lastPruneMu sync.Mutex
+
+	// User, if not nil, returns the name of the user making a request,
+	// which is recorded in the history of jobs and in audit records. By
+	// default, the user name from HTTP basic authentication is used, if
+	// any. Its password is not checked, so the default user name is
+	// whatever the client claims; set User to a function that
+	// authenticates the user to rely on it.
+	User func(r *http.Request) string
+
+	// MaxConcurrent is the maximum number of jobs that run at the same
+	// time. Further jobs wait in a queue. If it is zero, one job runs at
+	// a time, as the commands of all jobs share the command tree and can
+	// only run one after the other anyway. If it is negative, there is no
+	// limit, and jobs that cannot run yet wait with the status
+	// JobRunning rather than in the queue.
+	MaxConcurrent int
+
+	// CommandLimits is the maximum number of jobs of each command that
+	// run at the same time, keyed by the command path separated by slashes
+	// and starting with the root command, e.g. "app/run/steady". Commands
+	// that are not listed are only limited by MaxConcurrent.
+	CommandLimits map[string]int
+
+	// Priority, if not nil, returns the priority of a job. Queued jobs
+	// with a higher priority run first, and jobs with the same priority
+	// run in the order they were submitted.
+	Priority func(j Job) int
+
+	// Timeout is the maximum time a job may run for, not counting the time
+	// it waits in the queue. When it is reached, the context of the
+	// command, cmd.Context(), is canceled and the job ends with the status
+	// JobTimedOut once the command returns. If it is zero, there is no
+	// limit.
+	Timeout time.Duration
+
+	// CommandTimeouts overrides Timeout for individual commands, keyed
+	// like CommandLimits. A timeout of zero means no limit.
+	CommandTimeouts map[string]time.Duration
+
+	// queue holds the jobs waiting to run.
+	queue jobQueue
+
+	// AllowGET allows commands to be run with GET requests, with the flags
+	// in the query string, as in earlier versions. Such requests are not
+	// protected against cross-site request forgery, so by default commands
+	// must be run with POST requests.
+	AllowGET bool
+
+	// CSRFKey is the secret that CSRF tokens are derived from. If it is
+	// empty, a random key is generated when the server starts, so pages
+	// rendered before a restart have to be reloaded.
+	CSRFKey []byte
+
+	// RateLimit limits how often each client may start jobs or uploads.
+	// Clients are identified by the user name returned by User if User
+	// is set and the name is not empty, and otherwise by their IP address. Requests over the limit get
+	// status 429 with a Retry-After header.
+	RateLimit RateLimit
+
+	// CommandRateLimits limits how often each client may run individual
+	// commands, keyed like CommandLimits, in addition to RateLimit.
+	CommandRateLimits map[string]RateLimit
+
+	// limiters holds the token buckets of RateLimit and CommandRateLimits.
+	limiters limiters
+
+	// Webhooks are URLs that a WebhookPayload is posted to when any job
+	// finishes, and CommandWebhooks are URLs notified when jobs of
+	// individual commands finish, keyed like CommandLimits.
+	Webhooks        []string
+	CommandWebhooks map[string][]string
+
+	// WebhookSecret, if not empty, is used to sign webhook payloads. The
+	// hex-encoded HMAC-SHA256 of the body is sent in the X-Gobra-Signature
+	// header as "sha256=<signature>".
+	WebhookSecret string
+
+	// WebhookClient is the client used to post webhooks. If it is nil, a
+	// client with a 30 second timeout is used.
+	WebhookClient *http.Client
+
+	// Logger receives the log records of the server. If it is nil,
+	// slog.Default() is used. To turn logging off, use a logger whose
+	// handler discards records, such as slog.DiscardHandler.
+	Logger *slog.Logger
+
+	// AuditLogger receives an audit record for every job that finishes,
+	// with the user, remote address, command path, flags, duration and
+	// result of the job. If it is nil, Logger is used.
+	AuditLogger *slog.Logger
+
+	// Registry is the Prometheus registry that the metrics of the server
+	// are registered with and that is served at /metrics. If it is nil, a
+	// new registry is created, which also holds the Go runtime and process
+	// metrics.
+	Registry *prometheus.Registry
+	metrics  *metrics
+
+	// PublicURL is the address at which clients reach the server, such as
+	// "https://example.com/gobra". If it is set, links in webhook
+	// payloads are absolute.
+	PublicURL string
+
+	// schedules are the scheduled runs of commands, which are started by
+	// cron.
+	schedules   []*Schedule
+	cron        *cron.Cron
+	schedulesMu sync.Mutex
+
+	// uploads holds the resumable uploads in progress, keyed by ID.
+	uploads   map[string]*chunkedUpload
+	uploadsMu sync.Mutex
+
+	// runMu is held while a job uses the command tree, which cobra does
+	// not allow to be used by several goroutines at once.
+	runMu sync.Mutex
+
 	// PreRun, if not nil, will be run before executing the given commands with
 	// the given flags.
 	PreRun func(commands *[]string, flags *url.Values) error
@@ -342,13 +909,17 @@ func (s *Server) MakeFlagUploadable(names ...string) {
 }
 
 // Write method makes Server implements io.Writer.
-// It also transforms the input bytes to string then sends it to the websocket.
+// It sends the input bytes to the websocket as standard output.
 func (s *Server) Write(p []byte) (n int, err error) {
-	s.socketChannel <- fmt.Sprintf("%s", p)
-	return len(p), nil
+	return streamWriter{s, nil, streamStdout}.Write(p)
 }
 
 func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
+	if err := s.checkOrigin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	if r.URL.Path == "/" {
 		// Serves front-end if root is requested
 		if s.HTML != nil {
@@ -357,61 +928,100 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
-	} else if strings.HasPrefix(r.URL.Path, "/"+s.Root.Name()) {
+	} else if r.URL.Path == "/"+s.Root.Name() || strings.HasPrefix(r.URL.Path, "/"+s.Root.Name()+"/") {
 		// Serves API if path starts with root command name
 
 		if s.AllowCORS {
 			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Allow-Headers", "Prefer, "+CSRFHeader)
 		}
-
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if r.Method == http.MethodOptions {
 			return
 		}
-		cmds := strings.Split(r.URL.Path[1:], "/")
-		flags := r.Form
-
-		if err := s.PreRun(&cmds, &flags); err != nil {
-			http.Error(w, "running pre-run hook: "+err.Error(), http.StatusInternalServerError)
+		if r.Method != http.MethodPost && !s.AllowGET {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "commands must be run with POST requests", http.StatusMethodNotAllowed)
 			return
 		}
 
-		// Set arguments to run.
-		// Set cobra output to send to server instead.
-		s.Root.SetArgs(cmds[1:])
-		s.Root.SetOutput(s)
-
-		// Getting the command we need to set flags
-		c, _, _ := s.Root.Find(cmds[1:])
-		for key, values := range flags {
-			if err := c.Flags().Set(key, strings.Trim(values[0], "[]")); err != nil {
+		cmds, err := s.commandPath(strings.Split(r.URL.Path[1:], "/"))
+		if err != nil {
+			if isJSONRequest(r) {
+				writeJSON(w, http.StatusNotFound, &FieldError{Message: err.Error()})
+			} else {
+				http.Error(w, err.Error(), http.StatusNotFound)
+			}
+			return
+		}
+		var flags url.Values
+		var args []string
+		if isJSONRequest(r) {
+			var err *FieldError
+			if flags, args, err = s.decodeCommandRequest(w, r, cmds); err != nil {
+				writeJSON(w, http.StatusBadRequest, err)
+				return
+			}
+		} else {
+			if err := r.ParseForm(); err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			// The web interface sends the values of slice flags in
+			// brackets, as they are printed by pflag.
+			flags = make(url.Values, len(r.Form))
+			for k, v := range r.Form {
+				for _, value := range v {
+					flags.Add(k, strings.Trim(value, "[]"))
+				}
+			}
 		}
 
-		fmt.Println("Executing: ", cmds, flags)
-		_, err := s.Root.ExecuteC()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-		fmt.Fprintf(w, "Finished. ")
+		s.startJob(w, r, cmds, flags, args)
+
+	} else if r.URL.Path == "/jobs" || strings.HasPrefix(r.URL.Path, "/jobs/") {
+		// API end-point for job results and output files.
+		s.jobsHandler(w, r)
+
+	} else if r.URL.Path == "/schedules" || strings.HasPrefix(r.URL.Path, "/schedules/") {
+		// API end-point for scheduled runs of commands.
+		s.schedulesHandler(w, r)
+
+	} else if r.URL.Path == "/schema" {
+		// Description of the commands and flags for API clients.
+		s.schemaHandler(w, r)
+
+	} else if r.URL.Path == "/metrics" {
+		// Prometheus metrics.
+		s.metricsHandler(w, r)
+
+	} else if strings.HasPrefix(r.URL.Path, "/upload/chunked") {
+		// API end-point for resumable uploads of large files.
+		s.chunkedUploadHandler(w, r)
 
 	} else if strings.HasPrefix(r.URL.Path, "/upload") {
 		// API end-point for file uploading
 		// Store uploaded files to temporary folder.
+		if !s.allow(w, r, "") {
+			return
+		}
 
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxUploadSize())
 		if err := r.ParseMultipartForm(32 << 20); err != nil { // 32MB is held in memory.
 			http.Error(w, fmt.Sprintf("while parsing upload form: %v", err), http.StatusInternalServerError)
 			return
 		}
-		// flagName := r.MultipartForm.Value["name"][0]
-		var flagType string
+		var flagName, flagType string
+		if n := r.MultipartForm.Value["name"]; len(n) > 0 {
+			flagName = n[0]
+		}
 		if t := r.MultipartForm.Value["type"]; len(t) > 0 {
 			flagType = t[0]
 		}
 		fhs := r.MultipartForm.File["data"]
+		if len(fhs) == 0 {
+			http.Error(w, "no file uploaded", http.StatusBadRequest)
+			return
+		}
 		paths := make([]string, len(fhs))
 		for i, fh := range fhs {
 			file, err := fh.Open()
@@ -419,11 +1029,18 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, fmt.Sprintf("failed retrieving uploaded file: %v", err), http.StatusInternalServerError)
 				return
 			}
-			localPath, err := s.FileUploadFunc(file, fh.Filename)
+			var localPath string
+			if s.isArchiveFlag(flagName) {
+				localPath, err = s.extractUpload(file, fh.Size)
+			} else {
+				localPath, err = s.FileUploadFunc(file, fh.Filename)
+			}
+			file.Close()
 			if err != nil {
 				http.Error(w, fmt.Sprintf("failed opening/copying uploaded file: %v", err), http.StatusInternalServerError)
 				return
 			}
+			s.metrics.observeUpload("multipart", fh.Size)
 			paths[i] = localPath
 		}
 
@@ -445,7 +1062,7 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, fmt.Sprintf("failed opening/copying uploaded file: %v", err), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, string(response))
+		w.Write(response)
 
 	} else {
 		// Everything else gets a 404
@@ -453,6 +1070,110 @@ func (s *Server) handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// commandPath checks that cmds, the segments of a request path, name a
+// command and returns the path of the command from the root, which is
+// how the command is identified in jobs and in the per-command settings.
+// Aliases are resolved and empty segments, such as from a trailing slash,
+// are ignored. Any other segment that does not name a subcommand is an
+// error: positional arguments are given in the body of the request.
+func (s *Server) commandPath(cmds []string) ([]string, error) {
+	var names []string
+	for _, name := range cmds {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	for i := len(names); i > 0; i-- {
+		if path, ok := s.commandPaths[strings.Join(names[:i], "/")]; ok {
+			if i < len(names) {
+				return nil, fmt.Errorf("unknown command %q for %q", names[i], strings.Join(path, " "))
+			}
+			return path, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown command %q", strings.Join(cmds, "/"))
+}
+
+// indexCommands fills in s.commandPaths and s.commandFlags.
+func (s *Server) indexCommands() {
+	s.commandPaths = make(map[string][]string)
+	s.commandFlags = make(map[string]map[string]flagInfo)
+	var index func(c *cobra.Command, keys, path []string)
+	index = func(c *cobra.Command, keys, path []string) {
+		for _, k := range keys {
+			s.commandPaths[k] = path
+		}
+		s.commandFlags[strings.Join(path, "/")] = flagInfos(c)
+		for _, sub := range c.Commands() {
+			var subKeys []string
+			for _, k := range keys {
+				for _, name := range append([]string{sub.Name()}, sub.Aliases...) {
+					subKeys = append(subKeys, k+"/"+name)
+				}
+			}
+			index(sub, subKeys, append(path[:len(path):len(path)], sub.Name()))
+		}
+	}
+	index(s.Root, []string{s.Root.Name()}, []string{s.Root.Name()})
+}
+
+// startJob runs the given commands with the given flags and positional
+// arguments and writes the response: the job as JSON right away if the
+// client prefers an asynchronous response, the output as it is produced
+// if responses are streamed, and otherwise the result once the command
+// has finished.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, cmds []string, flags url.Values, args []string) {
+	if !s.allow(w, r, strings.Join(cmds, "/")) {
+		return
+	}
+	if s.PreRun != nil {
+		if err := s.PreRun(&cmds, &flags); err != nil {
+			http.Error(w, "running pre-run hook: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var err error
+		if cmds, err = s.commandPath(cmds); err != nil {
+			http.Error(w, "running pre-run hook: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	job, err := s.newJob(cmds, flags, args, s.user(r), r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if strings.Contains(r.Header.Get("Prefer"), "respond-async") {
+		// Return the job right away and let the client follow it.
+		started, _ := s.jobs.get(job.ID)
+		go s.run(job)
+		w.Header().Set("Location", "/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, started.Job)
+		return
+	}
+	if s.StreamResponses && !wantsJSON(r) {
+		s.streamJob(w, r, job)
+		return
+	}
+	err = s.run(job)
+	if wantsJSON(r) {
+		code := http.StatusOK
+		if err != nil {
+			code = http.StatusInternalServerError
+		}
+		writeJSON(w, code, job.Job)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "Finished. ")
+	for _, a := range job.Artifacts {
+		fmt.Fprintf(w, "\n%s: %s", a.Name, a.URL)
+	}
+}
+
 // This is from github.com/spf13/pflag for string slice flags.
 func writeAsCSV(vals []string) ([]byte, error) {
 	b := &bytes.Buffer{}
@@ -485,20 +1206,65 @@ func saveTempFileFunc() (func(io.Reader, string) (string, error), error) {
 	}, nil
 }
 
+// wsHandler sends messages to a websocket client. By default, the client
+// receives the messages of all jobs as they are sent. With the query
+// parameter job=<id>, it receives only the messages of that job, starting
+// with the stored messages whose sequence number is greater than the
+// since parameter, so that a client can reconnect without missing output.
+// If the job has finished and its messages are no longer stored, the
+// client receives a job-finished message and the connection is closed.
 func (s *Server) wsHandler(ws *websocket.Conn) {
+	s.metrics.addWebsocket(1)
+	defer s.metrics.addWebsocket(-1)
+	q := ws.Request().URL.Query()
+	job := q.Get("job")
+	since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+	sub, backlog, done := s.subscribe(job, since)
+	defer s.broadcaster.unsubscribe(sub)
+	for _, data := range backlog {
+		if err := websocket.JSON.Send(ws, data); err != nil {
+			s.logger().Warn("sending websocket message", "remote", ws.Request().RemoteAddr, "error", err)
+			return
+		}
+	}
+	if done {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
 	for {
 		// Receiving data from channel
-		data := <-s.socketChannel
-		if err := websocket.Message.Send(ws, data); err != nil {
-			fmt.Println("Error sending data.")
+		var data Message
+		select {
+		case m, ok := <-sub.ch:
+			if !ok {
+				// The client fell behind and has to reconnect.
+				return
+			}
+			data = m
+		case t := <-heartbeat.C:
+			data = heartbeatMessage(t)
+		}
+		if err := websocket.JSON.Send(ws, data); err != nil {
+			s.logger().Warn("sending websocket message", "remote", ws.Request().RemoteAddr, "error", err)
 			break
 		}
 	}
 }
 
-// Start starts the server.
-func (s *Server) Start() error {
-	s.socketChannel = make(chan string)
+// user returns the name of the user making the request.
+func (s *Server) user(r *http.Request) string {
+	if s.User != nil {
+		return s.User(r)
+	}
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// init sets the defaults of the server and prepares it to serve requests.
+func (s *Server) init() error {
+	s.broadcaster.size = s.ReplayBytes
 	if s.FileUploadFunc == nil {
 		var err error
 		s.FileUploadFunc, err = saveTempFileFunc()
@@ -506,19 +1272,76 @@ func (s *Server) Start() error {
 			return err
 		}
 	}
+	if s.JobStore == nil && s.HistoryFile != "" {
+		store, err := OpenBoltStore(s.HistoryFile)
+		if err != nil {
+			return err
+		}
+		s.JobStore = store
+	} else if s.JobStore == nil {
+		s.JobStore = NewMemoryStore()
+	}
+	s.indexCommands()
+	schema := s.commandSchema(s.Root)
+	s.schema = &schema
+	if err := s.markInterruptedJobs(); err != nil {
+		return err
+	}
+	if err := s.loadSchedules(); err != nil {
+		return err
+	}
+	if err := s.pruneHistory(); err != nil {
+		return fmt.Errorf("gobra: deleting old jobs: %v", err)
+	}
+	if err := s.initCSRFKey(); err != nil {
+		return err
+	}
+	var err error
+	if s.metrics, err = s.newMetrics(); err != nil {
+		return fmt.Errorf("gobra: registering metrics: %v", err)
+	}
+	s.schedulesMu.Lock()
+	s.cronScheduler().Start()
+	s.schedulesMu.Unlock()
 
 	if s.uploadableFlags == nil {
 		s.uploadableFlags = make(map[string]struct{})
 	}
 	var funcMaps = template.FuncMap{
-		"flagSetToSlice": flagSetToSlice,
-		"notHelpCommand": notHelpCommand,
-		"canUploadFile":  s.canUploadFile,
-		"isStringSlice":  func(s string) bool { return s == "stringSlice" },
+		"flagSetToSlice":  flagSetToSlice,
+		"notHelpCommand":  notHelpCommand,
+		"canUploadFile":   s.canUploadFile,
+		"isArchiveFlag":   s.isArchiveFlag,
+		"isOutputFlag":    s.isOutputFlag,
+		"isSensitiveFlag": s.isSensitiveFlag,
+		"protocolVersion": func() int { return ProtocolVersion },
+		"csrfToken":       s.CSRFToken,
+		"csrfHeader":      func() string { return CSRFHeader },
+		"isStringSlice":   func(s string) bool { return s == "stringSlice" },
 	}
 	s.tCmd = template.Must(template.New("commands").Funcs(funcMaps).Parse(commandTpl))
+	return nil
+}
+
+// Handler returns a handler that serves the front end, the API and the
+// websocket, for serving gobra from an http.Server other than the one
+// started by Start. It must only be called once.
+func (s *Server) Handler() (http.Handler, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", s.instrument(http.HandlerFunc(s.handler)))
+	mux.Handle("/ws", s.instrument(websocket.Handler(s.wsHandler)))
+	return mux, nil
+}
 
-	http.HandleFunc("/", s.handler)
-	http.Handle("/ws", websocket.Handler(s.wsHandler))
+// Start starts the server.
+func (s *Server) Start() error {
+	h, err := s.Handler()
+	if err != nil {
+		return err
+	}
+	http.Handle("/", h)
 	return http.ListenAndServe(s.ServerAddress, nil)
 }
@@ -0,0 +1,481 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/client"
+	"github.com/ctessum/gobra/gobratest"
+	"github.com/spf13/cobra"
+)
+
+// testTree returns the command tree used in the tests.
+func testTree() *cobra.Command {
+	root := &cobra.Command{Use: "app"}
+
+	var num1, num2 int
+	var output string
+	add := &cobra.Command{Use: "add", Short: "Add two numbers", RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.Println(num1 + num2)
+		if output == "" {
+			return nil
+		}
+		return ioutil.WriteFile(output, []byte(fmt.Sprintln(num1+num2)), 0644)
+	}}
+	add.Flags().IntVar(&num1, "num1", 1, "first number")
+	add.Flags().IntVar(&num2, "num2", 1, "second number")
+	add.Flags().StringVar(&output, "output", "sum.txt", "file to write the sum to")
+	math := &cobra.Command{Use: "math"}
+	math.AddCommand(add)
+
+	var path string
+	var paths []string
+	cat := &cobra.Command{Use: "cat", RunE: func(cmd *cobra.Command, args []string) error {
+		for _, p := range append([]string{path}, paths...) {
+			if p == "" {
+				continue
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			cmd.Print(string(b))
+		}
+		return nil
+	}}
+	cat.Flags().StringVar(&path, "path", "", "file to print")
+	cat.Flags().StringSliceVar(&paths, "paths", nil, "more files to print")
+
+	fail := &cobra.Command{Use: "fail", RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("it failed")
+	}}
+
+	progress := &cobra.Command{Use: "progress", Run: func(cmd *cobra.Command, args []string) {
+		for i := 1; i <= 3; i++ {
+			cmd.Printf("step %d\n", i)
+			gobra.ReportProgress(cmd.Context(), float64(i), 3, "stepping")
+		}
+	}}
+
+	var dir string
+	ls := &cobra.Command{Use: "ls", RunE: func(cmd *cobra.Command, args []string) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || path == dir {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			cmd.Println(filepath.ToSlash(rel))
+			return err
+		})
+	}}
+	ls.Flags().StringVar(&dir, "dir", "", "directory to list")
+
+	var text string
+	echo := &cobra.Command{Use: "echo", Run: func(cmd *cobra.Command, args []string) {
+		// Give other jobs a chance to run at the same time.
+		time.Sleep(10 * time.Millisecond)
+		cmd.Println(text)
+	}}
+	echo.Flags().StringVar(&text, "text", "", "text to print")
+
+	var duration time.Duration
+	sleep := &cobra.Command{Use: "sleep", RunE: func(cmd *cobra.Command, args []string) error {
+		select {
+		case <-time.After(duration):
+			cmd.Println("awake")
+			return nil
+		case <-cmd.Context().Done():
+			return cmd.Context().Err()
+		}
+	}}
+	sleep.Flags().DurationVar(&duration, "duration", time.Second, "how long to sleep for")
+
+	root.AddCommand(math, cat, fail, progress, ls, echo, sleep)
+	return root
+}
+
+// newServer starts s with the test command tree.
+func newServer(t *testing.T, s *gobra.Server) *gobratest.Server {
+	s.Root = testTree()
+	s.MakeFlagUploadable("path", "paths")
+	s.MakeFlagArchive("dir")
+	s.MakeFlagOutput("output")
+	return gobratest.NewServer(t, s)
+}
+
+func TestRun(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	r := ts.Run("app/math/add", url.Values{"num1": {"3"}, "num2": {"4"}}).
+		ExpectCode(http.StatusOK).
+		ExpectStatus(gobra.JobSucceeded).
+		ExpectOutput("7")
+	if got := string(r.Artifact("output")); got != "7\n" {
+		t.Errorf("output file = %q, want %q", got, "7\n")
+	}
+	if got := r.Job.Commands; strings.Join(got, "/") != "app/math/add" {
+		t.Errorf("job commands = %q", got)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	r := ts.Run("app/fail", nil).
+		ExpectCode(http.StatusInternalServerError).
+		ExpectStatus(gobra.JobFailed)
+	if r.Job.Error != "it failed" {
+		t.Errorf("job error = %q, want %q", r.Job.Error, "it failed")
+	}
+}
+
+func TestConcurrentJobs(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	var jobs []*gobra.Job
+	for i := 0; i < 10; i++ {
+		jobs = append(jobs, ts.Start("app/echo", url.Values{"text": {strconv.Itoa(i)}}))
+	}
+	for i, j := range jobs {
+		if j := ts.Wait(j.ID); j.Status != gobra.JobSucceeded {
+			t.Errorf("job %d: status = %s, error %q", i, j.Status, j.Error)
+		}
+		var out strings.Builder
+		if err := ts.API().Output(context.Background(), j.ID, &out); err != nil {
+			t.Fatal(err)
+		}
+		if want := strconv.Itoa(i) + "\n"; out.String() != want {
+			t.Errorf("job %d: output = %q, want %q", i, out.String(), want)
+		}
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	for _, test := range []struct {
+		body, want string
+		code       int
+	}{
+		{body: `{"flags": {"num1": 5, "num2": 6}}`, want: `"status":"succeeded"`, code: http.StatusOK},
+		{body: `{"flags": {"num1": "5"}}`, want: `"field":"flags.num1"`, code: http.StatusBadRequest},
+		{body: `{"flags": {"num3": 5}}`, want: `"field":"flags.num3"`, code: http.StatusBadRequest},
+		{body: `{"flag": {}}`, code: http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/app/math/add", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.code || !strings.Contains(string(b), test.want) {
+			t.Errorf("%s: got %d %s, want %d with %s", test.body, resp.StatusCode, b, test.code, test.want)
+		}
+	}
+}
+
+func TestRunJSONWhileRunning(t *testing.T) {
+	// Checking the flags of a JSON request must not touch the command
+	// tree, which a running job is using. Run with -race.
+	ts := newServer(t, &gobra.Server{})
+	j := ts.Start("app/sleep", url.Values{"duration": {"100ms"}})
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/app/sleep", strings.NewReader(`{"flags": {"duration": "1ms"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), `"status":"succeeded"`) {
+		t.Errorf("got %d %s", resp.StatusCode, b)
+	}
+	ts.Wait(j.ID)
+}
+
+func TestRunJSONBrackets(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	for _, test := range []struct {
+		flags map[string]interface{}
+		want  string
+	}{
+		{map[string]interface{}{"text": "[hi]"}, "[hi]\n"},
+		{map[string]interface{}{"text": "[a,b]"}, "[a,b]\n"},
+	} {
+		var out strings.Builder
+		_, err := ts.API().Run(context.Background(), client.Request{Commands: []string{"app", "echo"}, Flags: test.flags}, &out, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.want {
+			t.Errorf("output = %q, want %q", out.String(), test.want)
+		}
+	}
+
+	var out strings.Builder
+	paths := []string{filepath.Join("testdata", "input.txt"), filepath.Join("testdata", "input2.txt")}
+	_, err := ts.API().Run(context.Background(), client.Request{Commands: []string{"app", "cat"}, Flags: map[string]interface{}{"paths": paths}}, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello from a fixture\nsecond fixture\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	// Form values keep the brackets trimmed, as the web interface
+	// sends them.
+	r := ts.Run("app/cat", url.Values{"paths": {"[" + strings.Join(paths, ",") + "]"}}).ExpectStatus(gobra.JobSucceeded)
+	if want := "hello from a fixture\nsecond fixture\n"; r.Output != want {
+		t.Errorf("output = %q, want %q", r.Output, want)
+	}
+}
+
+func TestRunMethod(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	resp, err := ts.Client().Get(ts.URL + "/app/math/add?num1=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET status code = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	ts = newServer(t, &gobra.Server{AllowGET: true})
+	resp, err = ts.Client().Get(ts.URL + "/app/math/add?num1=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(b), "Finished.") {
+		t.Errorf("GET with AllowGET: got %d %s", resp.StatusCode, b)
+	}
+}
+
+func TestCrossSiteRequest(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	for _, test := range []struct {
+		name   string
+		header http.Header
+		code   int
+	}{
+		{"no headers", http.Header{}, http.StatusOK},
+		{"same origin", http.Header{"Sec-Fetch-Site": {"same-origin"}}, http.StatusOK},
+		{"cross site", http.Header{"Sec-Fetch-Site": {"cross-site"}}, http.StatusForbidden},
+		{"other origin", http.Header{"Origin": {"http://example.com"}}, http.StatusForbidden},
+		{"token", http.Header{"Sec-Fetch-Site": {"cross-site"}, gobra.CSRFHeader: {ts.Gobra.CSRFToken()}}, http.StatusOK},
+		{"wrong token", http.Header{"Sec-Fetch-Site": {"cross-site"}, gobra.CSRFHeader: {"nope"}}, http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/app/math/add", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header = test.header
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != test.code {
+			t.Errorf("%s: status code = %d, want %d", test.name, resp.StatusCode, test.code)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		HTML: template.Must(template.New("page").Parse("<html><body>{{.}}</body></html>")),
+	})
+	resp, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"<html><body>", `id="gobra-app"`, "num1"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	for _, p := range []string{"/nothing", "/jobs/nothing"} {
+		resp, err := ts.Client().Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status code = %d, want %d", p, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestRootNamePrefix(t *testing.T) {
+	// The other routes start with the name of the root command.
+	for _, name := range []string{"job", "s", "m"} {
+		root := &cobra.Command{Use: name}
+		root.AddCommand(&cobra.Command{Use: "hi", Run: func(cmd *cobra.Command, args []string) {
+			cmd.Println("hi")
+		}})
+		ts := gobratest.NewServer(t, &gobra.Server{Root: root})
+		for _, p := range []string{"/jobs", "/schedules", "/schema", "/metrics"} {
+			resp, err := ts.Client().Get(ts.URL + p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("root %s: %s: status code = %d, want %d", name, p, resp.StatusCode, http.StatusOK)
+			}
+		}
+		ts.Run(name+"/hi", nil).ExpectStatus(gobra.JobSucceeded).ExpectOutput("hi")
+	}
+}
+
+func TestJobs(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	r := ts.Run("app/math/add", url.Values{"num1": {"2"}}).ExpectStatus(gobra.JobSucceeded)
+	ts.Run("app/fail", nil)
+
+	resp, err := ts.Client().Get(ts.URL + "/jobs?status=succeeded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(b), r.Job.ID) || strings.Contains(string(b), "it failed") {
+		t.Errorf("GET /jobs?status=succeeded = %s", b)
+	}
+
+	j := ts.Wait(r.Job.ID)
+	if j.Status != gobra.JobSucceeded || len(j.Artifacts) != 1 {
+		t.Errorf("job = %+v", j)
+	}
+}
+
+func TestRerun(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	first := ts.Run("app/echo", url.Values{"text": {"first"}}).ExpectOutput("first")
+	// Flags that a job does not set have their default value, not the
+	// value of the previous job.
+	second := ts.Run("app/echo", nil).ExpectStatus(gobra.JobSucceeded)
+	if second.Output != "\n" {
+		t.Errorf("output without flags = %q, want %q", second.Output, "\n")
+	}
+
+	rerun := func(id string) string {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/jobs/"+id+"/rerun", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var j gobra.Job
+		if err := json.NewDecoder(resp.Body).Decode(&j); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || j.ID == id || j.Status != gobra.JobSucceeded {
+			t.Errorf("re-running job %s: %s: %+v", id, resp.Status, j)
+		}
+		var out strings.Builder
+		if err := ts.API().Output(context.Background(), j.ID, &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	if out := rerun(first.Job.ID); out != "first\n" {
+		t.Errorf("output of re-run = %q, want %q", out, "first\n")
+	}
+	if out := rerun(second.Job.ID); out != "\n" {
+		t.Errorf("output of re-run without flags = %q, want %q", out, "\n")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	schema := ts.Gobra.Schema()
+	if schema.Name != "app" || len(schema.Commands) != 7 {
+		t.Fatalf("schema = %+v", schema)
+	}
+	var add gobra.CommandSchema
+	for _, c := range schema.Commands {
+		if c.Name == "math" && len(c.Commands) == 1 {
+			add = c.Commands[0]
+		}
+	}
+	if !add.Runnable || len(add.Flags) != 3 {
+		t.Fatalf("add schema = %+v", add)
+	}
+	for _, f := range add.Flags {
+		if f.Name == "output" && !f.Output || f.Name == "num1" && (f.Type != "int" || f.Default != "1") {
+			t.Errorf("flag schema = %+v", f)
+		}
+	}
+}
+
+func TestSchemaWhileRunning(t *testing.T) {
+	// Serving the schema must not touch the command tree, which a
+	// running job is using. Run with -race.
+	ts := newServer(t, &gobra.Server{})
+	j := ts.Start("app/sleep", url.Values{"duration": {"100ms"}})
+	schema, err := ts.API().Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Name != "app" || len(schema.Commands) != 7 {
+		t.Errorf("schema = %+v", schema)
+	}
+	ts.Wait(j.ID)
+}
@@ -0,0 +1,358 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package gobratest runs gobra servers on httptest servers, for testing
+// cobra applications through gobra:
+//
+//	func TestAdd(t *testing.T) {
+//		ts := gobratest.NewServer(t, &gobra.Server{Root: cmd.Root})
+//		ts.Run("app/math/add", url.Values{"num1": {"3"}}).
+//			ExpectStatus(gobra.JobSucceeded).
+//			ExpectOutput("4")
+//	}
+package gobratest
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/client"
+	"golang.org/x/net/websocket"
+)
+
+// Timeout is how long the helpers wait for jobs and messages before
+// failing the test.
+var Timeout = 10 * time.Second
+
+// Server is a gobra server running on an httptest.Server.
+type Server struct {
+	*httptest.Server
+
+	// Gobra is the server under test.
+	Gobra *gobra.Server
+
+	t testing.TB
+}
+
+// NewServer starts s on an httptest.Server, which is closed at the end of
+// the test. Unless they are set, s gets a job store in memory, if it has
+// no history file either, and a logger that discards its output.
+func NewServer(t testing.TB, s *gobra.Server) *Server {
+	t.Helper()
+	if s.JobStore == nil && s.HistoryFile == "" {
+		s.JobStore = gobra.NewMemoryStore()
+	}
+	if s.Logger == nil {
+		s.Logger = slog.New(slog.NewTextHandler(ioutil.Discard, nil))
+	}
+	h, err := s.Handler()
+	if err != nil {
+		t.Fatalf("gobratest: starting server: %v", err)
+	}
+	ts := &Server{Server: httptest.NewServer(h), Gobra: s, t: t}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// API returns a client of the API of the server.
+func (ts *Server) API() *client.Client {
+	c := client.New(ts.URL)
+	c.HTTPClient = ts.Client()
+	return c
+}
+
+// Result is the result of a command request.
+type Result struct {
+	t testing.TB
+	s *Server
+
+	// StatusCode and Body are those of the response.
+	StatusCode int
+	Body       []byte
+
+	// Job is the finished job, and Output its output. Job is nil if the
+	// request was rejected.
+	Job    *gobra.Job
+	Output string
+}
+
+// Run runs the command with the given path, such as "app/math/add", with
+// the given flag values and returns the result once it has finished.
+func (ts *Server) Run(path string, flags url.Values) *Result {
+	ts.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/"+path, strings.NewReader(flags.Encode()))
+	if err != nil {
+		ts.t.Fatalf("gobratest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatalf("gobratest: running %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	r := &Result{t: ts.t, s: ts, StatusCode: resp.StatusCode}
+	if r.Body, err = ioutil.ReadAll(resp.Body); err != nil {
+		ts.t.Fatalf("gobratest: running %s: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		return r
+	}
+	r.Job = new(gobra.Job)
+	if err := json.Unmarshal(r.Body, r.Job); err != nil {
+		ts.t.Fatalf("gobratest: decoding job: %v", err)
+	}
+	var out strings.Builder
+	if err := ts.API().Output(context.Background(), r.Job.ID, &out); err != nil {
+		ts.t.Fatalf("gobratest: retrieving output: %v", err)
+	}
+	r.Output = out.String()
+	return r
+}
+
+// Start starts the command with the given path with the given flag values
+// without waiting for it to finish, and returns the queued or running job.
+func (ts *Server) Start(path string, flags url.Values) *gobra.Job {
+	ts.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/"+path, strings.NewReader(flags.Encode()))
+	if err != nil {
+		ts.t.Fatalf("gobratest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Prefer", "respond-async")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		ts.t.Fatalf("gobratest: starting %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		b, _ := ioutil.ReadAll(resp.Body)
+		ts.t.Fatalf("gobratest: starting %s: %s: %s", path, resp.Status, b)
+	}
+	var j gobra.Job
+	if err := json.NewDecoder(resp.Body).Decode(&j); err != nil {
+		ts.t.Fatalf("gobratest: decoding job: %v", err)
+	}
+	return &j
+}
+
+// Wait waits for the job with the given ID to finish and returns it.
+func (ts *Server) Wait(id string) *gobra.Job {
+	ts.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	j, err := ts.API().Wait(ctx, id)
+	if err != nil {
+		ts.t.Fatalf("gobratest: waiting for job %s: %v", id, err)
+	}
+	return j
+}
+
+// Upload uploads the fixture file at path for the named flag and returns
+// the path of the file on the server, to be passed to the flag.
+func (ts *Server) Upload(flag, path string) string {
+	ts.t.Helper()
+	p, err := ts.API().Upload(context.Background(), flag, path)
+	if err != nil {
+		ts.t.Fatalf("gobratest: uploading %s: %v", path, err)
+	}
+	return p
+}
+
+// ExpectCode reports an error if the response did not have the given
+// status code.
+func (r *Result) ExpectCode(code int) *Result {
+	r.t.Helper()
+	if r.StatusCode != code {
+		r.t.Errorf("status code = %d, want %d; body: %s", r.StatusCode, code, r.Body)
+	}
+	return r
+}
+
+// ExpectStatus reports an error if the job did not finish with the given
+// status.
+func (r *Result) ExpectStatus(status gobra.JobStatus) *Result {
+	r.t.Helper()
+	switch {
+	case r.Job == nil:
+		r.t.Errorf("request failed with status code %d: %s", r.StatusCode, r.Body)
+	case r.Job.Status != status:
+		r.t.Errorf("job status = %s (error %q), want %s", r.Job.Status, r.Job.Error, status)
+	}
+	return r
+}
+
+// ExpectOutput reports an error if the output of the job does not
+// contain s.
+func (r *Result) ExpectOutput(s string) *Result {
+	r.t.Helper()
+	if !strings.Contains(r.Output, s) {
+		r.t.Errorf("output %q does not contain %q", r.Output, s)
+	}
+	return r
+}
+
+// Artifact returns the contents of the file the job wrote to the named
+// output flag, failing the test if there is none.
+func (r *Result) Artifact(name string) []byte {
+	r.t.Helper()
+	if r.Job == nil {
+		r.t.Fatalf("request failed with status code %d: %s", r.StatusCode, r.Body)
+	}
+	for _, a := range r.Job.Artifacts {
+		if a.Name != name {
+			continue
+		}
+		var b strings.Builder
+		if err := r.s.API().Download(context.Background(), a, &b); err != nil {
+			r.t.Fatalf("gobratest: downloading %s: %v", name, err)
+		}
+		return []byte(b.String())
+	}
+	r.t.Fatalf("job has no artifact %q", name)
+	return nil
+}
+
+// Websocket is a connection to the websocket of a server that collects
+// the messages it receives.
+type Websocket struct {
+	t    testing.TB
+	conn *websocket.Conn
+
+	mu       sync.Mutex
+	messages []gobra.Message
+	err      error
+
+	// received is closed and replaced when a message is received.
+	received chan struct{}
+}
+
+// Websocket connects to the websocket of the server with the given query,
+// such as "job=<id>". The connection is closed at the end of the test.
+// Messages sent before the connection is established are only received
+// with the job parameter, which replays them.
+func (ts *Server) Websocket(query string) *Websocket {
+	ts.t.Helper()
+	u := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	if query != "" {
+		u += "?" + query
+	}
+	conn, err := websocket.Dial(u, "", ts.URL)
+	if err != nil {
+		ts.t.Fatalf("gobratest: connecting to websocket: %v", err)
+	}
+	ws := &Websocket{t: ts.t, conn: conn, received: make(chan struct{})}
+	ts.t.Cleanup(ws.Close)
+	go ws.receive()
+	return ws
+}
+
+func (ws *Websocket) receive() {
+	for {
+		var m gobra.Message
+		err := websocket.JSON.Receive(ws.conn, &m)
+		ws.mu.Lock()
+		if err != nil {
+			ws.err = err
+		} else if m.Type != gobra.MessageHeartbeat {
+			ws.messages = append(ws.messages, m)
+		}
+		close(ws.received)
+		ws.received = make(chan struct{})
+		ws.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close closes the connection.
+func (ws *Websocket) Close() {
+	ws.conn.Close()
+}
+
+// Messages returns the messages received so far, other than heartbeats.
+func (ws *Websocket) Messages() []gobra.Message {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return append([]gobra.Message(nil), ws.messages...)
+}
+
+// WaitFor waits for a message for which match returns true and returns
+// it, failing the test if none is received within Timeout.
+func (ws *Websocket) WaitFor(match func(gobra.Message) bool) gobra.Message {
+	ws.t.Helper()
+	timeout := time.After(Timeout)
+	for i := 0; ; {
+		ws.mu.Lock()
+		for ; i < len(ws.messages); i++ {
+			if match(ws.messages[i]) {
+				m := ws.messages[i]
+				ws.mu.Unlock()
+				return m
+			}
+		}
+		received, err := ws.received, ws.err
+		ws.mu.Unlock()
+		if err != nil {
+			if err == io.EOF {
+				ws.t.Fatalf("gobratest: websocket closed while waiting for a message")
+			}
+			ws.t.Fatalf("gobratest: receiving websocket message: %v", err)
+		}
+		select {
+		case <-received:
+		case <-timeout:
+			ws.t.Fatalf("gobratest: no matching websocket message after %v", Timeout)
+		}
+	}
+}
+
+// Output waits for the job with the given ID to finish and returns the
+// output received for it.
+func (ws *Websocket) Output(id string) string {
+	ws.t.Helper()
+	ws.WaitFor(func(m gobra.Message) bool {
+		return m.JobID == id && m.Type == gobra.MessageJobFinished
+	})
+	var out strings.Builder
+	for _, m := range ws.Messages() {
+		if m.JobID == id && m.Type == gobra.MessageOutput {
+			out.WriteString(m.Data)
+		}
+	}
+	return out.String()
+}
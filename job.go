@@ -0,0 +1,577 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ctessum/gobra/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// These types describe jobs to clients; see package api.
+type (
+	JobStatus = api.JobStatus
+	Job       = api.Job
+	Artifact  = api.Artifact
+	Upload    = api.Upload
+)
+
+// These are the possible states of a Job.
+const (
+	JobQueued    = api.JobQueued
+	JobRunning   = api.JobRunning
+	JobSucceeded = api.JobSucceeded
+	JobFailed    = api.JobFailed
+	JobCanceled  = api.JobCanceled
+	JobTimedOut  = api.JobTimedOut
+)
+
+// job is a Job that the server is running, with the state needed to run
+// it.
+type job struct {
+	Job
+
+	// dir holds the output files of the job, which are listed in outputs
+	// until the job finishes.
+	dir     string
+	outputs []Artifact
+
+	// flags are the flag values the command is run with.
+	flags url.Values
+
+	// output holds the output of the command.
+	output *jobOutput
+
+	// queued is the entry of the job in the queue.
+	queued *queuedJob
+}
+
+// jobList holds the jobs run by a server. Changes to jobs after they
+// have been added must be made with update.
+type jobList struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func (l *jobList) add(j *job) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.jobs == nil {
+		l.jobs = make(map[string]*job)
+	}
+	l.jobs[j.ID] = j
+}
+
+// get returns a copy of the job with the given ID.
+func (l *jobList) get(id string) (job, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	j, ok := l.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// update calls f while holding the lock on the jobs.
+func (l *jobList) update(f func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f()
+}
+
+func (l *jobList) remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.jobs, id)
+}
+
+// MakeFlagOutput registers the given flag name(s) as output files. Users
+// cannot set these flags. Instead, each job is given its own output
+// directory and the flag is set to a path inside it, keeping the base name
+// of the flag's default value if it has one. Files written to these paths
+// are listed as artifacts of the job and can be downloaded from
+// /jobs/<id>/artifacts/<flag name>.
+func (s *Server) MakeFlagOutput(names ...string) {
+	if s.outputFlags == nil {
+		s.outputFlags = make(map[string]struct{})
+	}
+	for _, name := range names {
+		s.outputFlags[name] = struct{}{}
+	}
+}
+
+func (s *Server) isOutputFlag(name string) bool {
+	_, ok := s.outputFlags[name]
+	return ok
+}
+
+// newJob creates, registers and queues a job for the given commands,
+// flags and positional arguments, started by user from remoteAddr. It
+// must be followed by a call to run.
+func (s *Server) newJob(cmds []string, flags url.Values, args []string, user, remoteAddr string) (*job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	j := &job{
+		Job: Job{
+			ID:         id,
+			Commands:   cmds,
+			Flags:      s.redactFlags(flags),
+			Args:       args,
+			User:       user,
+			RemoteAddr: remoteAddr,
+			Status:     JobQueued,
+			Start:      time.Now(),
+		},
+		flags:  flags,
+		output: s.newOutput(),
+	}
+	for name, values := range flags {
+		if s.canUploadFile(name) && len(values) > 0 && values[0] != "" {
+			j.Uploads = append(j.Uploads, Upload{Flag: name, Filename: filepath.Base(values[0]), Path: values[0]})
+		}
+	}
+	if err := s.JobStore.Put(j.Job); err != nil {
+		return nil, fmt.Errorf("gobra: saving job: %v", err)
+	}
+	s.enqueue(j)
+	s.jobs.add(j)
+	return j, nil
+}
+
+// job returns the job with the given ID, whether it is running or in
+// the history.
+func (s *Server) job(id string) (job, error) {
+	if j, ok := s.jobs.get(id); ok {
+		return j, nil
+	}
+	j, err := s.JobStore.Get(id)
+	return job{Job: j}, err
+}
+
+// run executes the command of the job and records the result in it.
+func (s *Server) run(j *job) error {
+	if err := s.wait(j); err != nil {
+		s.finish(j, err)
+		s.send(Message{Type: MessageJobFinished, JobID: j.ID, Status: j.Status, Error: j.Error})
+		return err
+	}
+	defer s.done(j)
+	s.jobs.update(func() {
+		j.Status = JobRunning
+		j.Start = time.Now()
+		j.Position = 0
+	})
+	if err := s.JobStore.Put(j.Job); err != nil {
+		s.logger().Error("saving job", "job", j.ID, "error", err)
+	}
+
+	s.send(Message{Type: MessageJobStarted, JobID: j.ID, Commands: j.Commands})
+	s.runMu.Lock()
+	err := s.prepare(j)
+	if err != nil {
+		s.send(Message{Type: MessageError, JobID: j.ID, Error: err.Error()})
+	} else {
+		err = s.execute(j)
+	}
+	s.finish(j, err)
+	s.runMu.Unlock()
+	s.send(Message{Type: MessageJobFinished, JobID: j.ID, Status: j.Status, Error: j.Error})
+	go s.notify(j.Job)
+	return err
+}
+
+// prepare sets up the command tree to run the job.
+func (s *Server) prepare(j *job) error {
+	// Set arguments to run.
+	// Set cobra output and errors to send to server instead.
+	// Arguments follow "--" so that they are not taken for flags.
+	if len(j.Args) > 0 {
+		s.Root.SetArgs(append(append(append([]string(nil), j.Commands[1:]...), "--"), j.Args...))
+	} else {
+		s.Root.SetArgs(j.Commands[1:])
+	}
+	s.Root.SetOut(streamWriter{s, j, streamStdout})
+	s.Root.SetErr(streamWriter{s, j, streamStderr})
+
+	// Getting the command we need to set flags
+	c, _, err := s.Root.Find(j.Commands[1:])
+	if err != nil {
+		return err
+	}
+	for key, values := range j.flags {
+		if s.isOutputFlag(key) {
+			continue
+		}
+		if err := setFlag(c, key, values[0]); err != nil {
+			if s.isSensitiveFlag(key) {
+				// The error would show the value.
+				return fmt.Errorf("invalid argument %q for --%s flag", redacted, key)
+			}
+			return err
+		}
+	}
+	if err := s.resetFlags(j, c); err != nil {
+		return err
+	}
+	return s.setOutputFlags(j, c)
+}
+
+func (s *Server) execute(j *job) error {
+	s.logger().Info("executing command", "job", j.ID, "command", strings.Join(j.Commands, "/"), "flags", j.Flags)
+	if s.CaptureStdio {
+		restore, err := captureStdio(streamWriter{s, j, streamStdout}, streamWriter{s, j, streamStderr})
+		if err != nil {
+			return fmt.Errorf("gobra: capturing standard streams: %v", err)
+		}
+		defer func() {
+			if err := restore(); err != nil {
+				s.logger().Error("capturing standard streams", "job", j.ID, "error", err)
+			}
+		}()
+	}
+	ctx := context.WithValue(context.Background(), jobContextKey, jobContext{s, j})
+	timeout := s.timeout(j)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// Cobra only gives subcommands the context of the root command if they
+	// do not have one yet, which they do from the previous run.
+	c, _, err := s.Root.Find(j.Commands[1:])
+	if err != nil {
+		return err
+	}
+	c.SetContext(ctx)
+	_, err = s.Root.ExecuteContextC(ctx)
+	if ctx.Err() == context.DeadlineExceeded {
+		return timeoutError(timeout)
+	}
+	return err
+}
+
+// timeout returns the time the job is allowed to run for, or zero if
+// there is no limit.
+func (s *Server) timeout(j *job) time.Duration {
+	if d, ok := s.CommandTimeouts[strings.Join(j.Commands, "/")]; ok {
+		return d
+	}
+	return s.Timeout
+}
+
+// timeoutError is the error of a job that ran for longer than its timeout.
+type timeoutError time.Duration
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", time.Duration(e))
+}
+
+// setFlag sets the flag of c with the given name to value. Slice flags
+// are replaced by the comma-separated values rather than appended to.
+func setFlag(c *cobra.Command, name, value string) error {
+	f := c.Flags().Lookup(name)
+	if f == nil {
+		return c.Flags().Set(name, value)
+	}
+	sv, ok := f.Value.(pflag.SliceValue)
+	if !ok {
+		return c.Flags().Set(name, value)
+	}
+	var vals []string
+	if value != "" {
+		var err error
+		if vals, err = csv.NewReader(strings.NewReader(value)).Read(); err != nil {
+			return fmt.Errorf("invalid argument %q for --%s flag: %v", value, name, err)
+		}
+	}
+	if err := sv.Replace(vals); err != nil {
+		return fmt.Errorf("invalid argument %q for --%s flag: %v", value, name, err)
+	}
+	f.Changed = true
+	return nil
+}
+
+// resetFlags sets the flags of c that the job does not set back to their
+// default values, so that a job never runs with the flags of a previous
+// one, whether they are secrets or just not recorded with this job.
+// Output flags are set by setOutputFlags.
+func (s *Server) resetFlags(j *job, c *cobra.Command) error {
+	var err error
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if _, ok := j.flags[f.Name]; err != nil || ok || s.isOutputFlag(f.Name) {
+			return
+		}
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			var vals []string
+			if def := strings.Trim(f.DefValue, "[]"); def != "" {
+				vals = strings.Split(def, ",")
+			}
+			err = sv.Replace(vals)
+		} else {
+			err = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	})
+	return err
+}
+
+// setOutputFlags points the output flags of c to files in the output
+// directory of the job.
+func (s *Server) setOutputFlags(j *job, c *cobra.Command) error {
+	var err error
+	c.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || !s.isOutputFlag(f.Name) {
+			return
+		}
+		if j.dir == "" {
+			if j.dir, err = ioutil.TempDir(s.OutputDir, "gobra-job"); err != nil {
+				err = fmt.Errorf("gobra: creating job output directory: %v", err)
+				return
+			}
+		}
+		name := f.Name
+		if base := filepath.Base(f.DefValue); f.DefValue != "" && base != "." && base != string(filepath.Separator) {
+			name = base
+		}
+		dir := filepath.Join(j.dir, f.Name)
+		if err = os.Mkdir(dir, 0770); err != nil {
+			err = fmt.Errorf("gobra: creating job output directory: %v", err)
+			return
+		}
+		p := filepath.Join(dir, name)
+		if err = f.Value.Set(p); err != nil {
+			return
+		}
+		j.outputs = append(j.outputs, Artifact{
+			Name:     f.Name,
+			Filename: name,
+			URL:      path.Join("/jobs", j.ID, "artifacts", f.Name),
+			Path:     p,
+		})
+	})
+	return err
+}
+
+// finish records the result of the job and keeps the artifacts that were
+// actually written.
+func (s *Server) finish(j *job, err error) {
+	j.output.close()
+	var artifacts []Artifact
+	for _, a := range j.outputs {
+		if fi, err := os.Stat(a.Path); err == nil && fi.Mode().IsRegular() {
+			a.Size = fi.Size()
+			artifacts = append(artifacts, a)
+		}
+	}
+	s.jobs.update(func() {
+		j.End = time.Now()
+		j.Position = 0
+		if err == errJobCanceled {
+			j.Status = JobCanceled
+			j.Error = err.Error()
+		} else if _, ok := err.(timeoutError); ok {
+			j.Status = JobTimedOut
+			j.Error = err.Error()
+		} else if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+		} else {
+			j.Status = JobSucceeded
+		}
+		j.Artifacts = artifacts
+	})
+	s.metrics.observeJob(j.Job, s.commandLabel(j.Commands))
+	s.audit(j.Job)
+
+	// Move the job from the running jobs to the history.
+	if err := s.JobStore.Put(j.Job); err != nil {
+		s.logger().Error("saving job", "job", j.ID, "error", err)
+	}
+	output := new(bytes.Buffer)
+	j.output.WriteTo(output)
+	if err := s.JobStore.PutOutput(j.ID, output.Bytes()); err != nil {
+		s.logger().Error("saving job output", "job", j.ID, "error", err)
+	}
+	s.jobs.remove(j.ID)
+	s.maybePruneHistory()
+}
+
+// jobsHandler serves information about jobs:
+//
+//	GET /jobs?command=&status=&user=&since=&limit=
+//	                                      lists the jobs that match the
+//	                                      query, as for JobQuery.
+//	GET /jobs/<id>                        returns the job as JSON.
+//	GET /jobs/<id>/output                 returns the output of the command.
+//	GET /jobs/<id>/events                 streams the messages of the job as
+//	                                      server-sent events.
+//	GET /jobs/<id>/artifacts/<flag name>  downloads an output file.
+//	POST /jobs/<id>/cancel                removes a queued job from the queue.
+//	POST /jobs/<id>/rerun                 starts a new job with the same
+//	                                      commands and flags, responding as
+//	                                      for a command request.
+func (s *Server) jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.AllowCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Prefer, "+CSRFHeader)
+	}
+	if r.Method == http.MethodOptions {
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/"), "/")
+	if parts[0] == "" {
+		s.listJobs(w, r)
+		return
+	}
+	j, err := s.job(parts[0])
+	if err == ErrJobNotFound {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case len(parts) == 1:
+		writeJSON(w, http.StatusOK, j.Job)
+	case len(parts) == 2 && parts[1] == "events":
+		s.eventsHandler(w, r, j.ID)
+	case len(parts) == 2 && parts[1] == "output":
+		if j.output != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			j.output.WriteTo(w)
+			return
+		}
+		output, err := s.JobStore.Output(j.ID)
+		if err != nil && err != ErrJobNotFound {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(output)
+	case len(parts) == 3 && parts[1] == "artifacts":
+		for _, a := range j.Artifacts {
+			if a.Name == parts[2] {
+				serveArtifact(w, r, a)
+				return
+			}
+		}
+		http.Error(w, "artifact not found", http.StatusNotFound)
+	case len(parts) == 2 && (parts[1] == "cancel" || parts[1] == "rerun") && r.Method != http.MethodPost:
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	case len(parts) == 2 && parts[1] == "cancel":
+		if !s.cancel(j.ID) {
+			http.Error(w, "job is not queued", http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case len(parts) == 2 && parts[1] == "rerun":
+		flags := make(url.Values, len(j.Flags))
+		for k, v := range j.Flags {
+			// The values of sensitive flags were not kept.
+			if !s.isSensitiveFlag(k) {
+				flags[k] = append([]string(nil), v...)
+			}
+		}
+		s.startJob(w, r, append([]string(nil), j.Commands...), flags, append([]string(nil), j.Args...))
+	default:
+		http.Error(w, "404 Page not Found", http.StatusNotFound)
+	}
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := JobQuery{
+		Command: strings.Trim(q.Get("command"), "/"),
+		Status:  JobStatus(q.Get("status")),
+		User:    q.Get("user"),
+	}
+	if v := q.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since time %q: %v", v, err), http.StatusBadRequest)
+			return
+		}
+		query.Since = t
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		query.Limit = n
+	}
+	jobs, err := s.JobStore.List(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []Job{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func serveArtifact(w http.ResponseWriter, r *http.Request, a Artifact) {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		http.Error(w, "artifact not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	http.ServeContent(w, r, a.Filename, fi.ModTime(), f)
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
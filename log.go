@@ -0,0 +1,58 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// logger returns the logger of the server.
+func (s *Server) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+// audit writes the audit record of a finished job.
+func (s *Server) audit(j Job) {
+	logger := s.AuditLogger
+	if logger == nil {
+		logger = s.logger()
+	}
+	logger.Info("audit",
+		"job", j.ID,
+		"user", j.User,
+		"remote", j.RemoteAddr,
+		"schedule", j.Schedule,
+		"command", strings.Join(j.Commands, "/"),
+		"flags", j.Flags,
+		"args", j.Args,
+		"duration", j.End.Sub(j.Start),
+		"status", j.Status,
+		"error", j.Error,
+	)
+}
@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"context"
+	"time"
+
+	"github.com/ctessum/gobra/api"
+)
+
+// ProtocolVersion is the version of the Message format. It is increased
+// whenever a change is made that existing clients cannot handle.
+const ProtocolVersion = api.ProtocolVersion
+
+// heartbeatInterval is how often a heartbeat is sent to idle websockets.
+const heartbeatInterval = 30 * time.Second
+
+// These types are the messages sent to clients; see package api.
+type (
+	MessageType = api.MessageType
+	Message     = api.Message
+	Progress    = api.Progress
+)
+
+// These are the types of messages sent to clients.
+const (
+	MessageOutput      = api.MessageOutput
+	MessageJobQueued   = api.MessageJobQueued
+	MessageJobStarted  = api.MessageJobStarted
+	MessageJobFinished = api.MessageJobFinished
+	MessageProgress    = api.MessageProgress
+	MessageError       = api.MessageError
+	MessageHeartbeat   = api.MessageHeartbeat
+)
+
+// send sends m to the websocket clients, filling in its version and time.
+func (s *Server) send(m Message) {
+	m.Version = ProtocolVersion
+	if m.Time.IsZero() {
+		m.Time = time.Now()
+	}
+	s.broadcaster.publish(m)
+}
+
+type contextKey int
+
+const jobContextKey contextKey = 0
+
+// jobContext is stored in the context of running commands.
+type jobContext struct {
+	s   *Server
+	job *job
+}
+
+// ReportProgress reports to clients that done out of total units of work
+// have been completed, with an optional message describing the current
+// step. ctx must be the context of a command run by gobra, which is
+// available as cmd.Context(). When the command is not run by gobra,
+// ReportProgress does nothing.
+func ReportProgress(ctx context.Context, done, total float64, message string) {
+	if ctx == nil {
+		return
+	}
+	jc, ok := ctx.Value(jobContextKey).(jobContext)
+	if !ok {
+		return
+	}
+	jc.s.send(Message{
+		Type:     MessageProgress,
+		JobID:    jc.job.ID,
+		Progress: &Progress{Done: done, Total: total, Message: message},
+	})
+}
@@ -0,0 +1,233 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics are the Prometheus metrics of a server. The methods do nothing
+// on a nil *metrics.
+type metrics struct {
+	jobs            *prometheus.CounterVec
+	jobDuration     *prometheus.HistogramVec
+	websockets      prometheus.Gauge
+	uploads         *prometheus.CounterVec
+	uploadBytes     *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+}
+
+// newMetrics creates the metrics of s and registers them with
+// s.Registry, creating it if needed.
+func (s *Server) newMetrics() (*metrics, error) {
+	if s.Registry == nil {
+		s.Registry = prometheus.NewRegistry()
+		s.Registry.MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+	}
+	m := &metrics{
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobra_jobs_total",
+			Help: "Number of jobs that finished, by command path and status.",
+		}, []string{"command", "status"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gobra_job_duration_seconds",
+			Help:    "Time jobs ran for, not counting time in the queue, by command path and status.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 4, 10),
+		}, []string{"command", "status"}),
+		websockets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gobra_websocket_connections",
+			Help: "Number of open websocket connections.",
+		}),
+		uploads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobra_uploads_total",
+			Help: "Number of files uploaded, by upload protocol.",
+		}, []string{"protocol"}),
+		uploadBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gobra_upload_bytes_total",
+			Help: "Number of bytes uploaded, by upload protocol.",
+		}, []string{"protocol"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gobra_http_request_duration_seconds",
+			Help:    "Latency of HTTP requests, by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+	}
+	queueDepth := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gobra_queue_depth",
+		Help: "Number of jobs waiting in the queue.",
+	}, func() float64 {
+		s.queue.mu.Lock()
+		defer s.queue.mu.Unlock()
+		return float64(len(s.queue.waiting))
+	})
+	running := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gobra_jobs_running",
+		Help: "Number of jobs running.",
+	}, func() float64 {
+		s.queue.mu.Lock()
+		defer s.queue.mu.Unlock()
+		return float64(s.queue.running)
+	})
+	for _, c := range []prometheus.Collector{m.jobs, m.jobDuration, m.websockets,
+		m.uploads, m.uploadBytes, m.requestDuration, queueDepth, running} {
+		if err := s.Registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// observeJob records a finished job of the given command, as returned by
+// Server.commandLabel.
+func (m *metrics) observeJob(j Job, command string) {
+	if m == nil {
+		return
+	}
+	m.jobs.WithLabelValues(command, string(j.Status)).Inc()
+	m.jobDuration.WithLabelValues(command, string(j.Status)).Observe(j.End.Sub(j.Start).Seconds())
+}
+
+// addWebsocket adds n to the number of open websocket connections.
+func (m *metrics) addWebsocket(n float64) {
+	if m == nil {
+		return
+	}
+	m.websockets.Add(n)
+}
+
+// observeUpload records an uploaded file of the given size.
+func (m *metrics) observeUpload(protocol string, size int64) {
+	if m == nil {
+		return
+	}
+	m.uploads.WithLabelValues(protocol).Inc()
+	m.uploadBytes.WithLabelValues(protocol).Add(float64(size))
+}
+
+// commandLabel returns the command path of cmds for labelling metrics,
+// or "unknown" if it is not a command of Root, so that the number of
+// label values is bounded by the size of the command tree.
+func (s *Server) commandLabel(cmds []string) string {
+	path, err := s.commandPath(cmds)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.Join(path, "/")
+}
+
+// instrument records the latency of the requests served by h and logs
+// them at the debug level.
+func (s *Server) instrument(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		d := time.Since(start)
+		route := s.route(r.URL.Path)
+		if s.metrics != nil {
+			s.metrics.requestDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.code)).Observe(d.Seconds())
+		}
+		s.logger().Debug("request", "method", r.Method, "path", r.URL.Path, "route", route,
+			"code", rec.code, "duration", d, "remote", r.RemoteAddr, "user", s.user(r))
+	})
+}
+
+// route returns the name of the route that serves the given path, for
+// labelling metrics. Commands are all grouped under the root command.
+func (s *Server) route(p string) string {
+	switch {
+	case p == "/" || p == "/ws" || p == "/metrics" || p == "/schema":
+		return p
+	case strings.HasPrefix(p, "/"+s.Root.Name()):
+		return "/" + s.Root.Name()
+	case p == "/jobs" || strings.HasPrefix(p, "/jobs/"):
+		return "/jobs"
+	case p == "/schedules" || strings.HasPrefix(p, "/schedules/"):
+		return "/schedules"
+	case strings.HasPrefix(p, "/upload/chunked"):
+		return "/upload/chunked"
+	case strings.HasPrefix(p, "/upload"):
+		return "/upload"
+	default:
+		return "other"
+	}
+}
+
+// metricsHandler serves the metrics in the Prometheus format.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.Registry == nil {
+		http.Error(w, "metrics are not available", http.StatusNotFound)
+		return
+	}
+	promhttp.HandlerFor(s.Registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// statusRecorder records the status code written to a ResponseWriter,
+// passing on flushes and hijacks for streamed responses and websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	code        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.code = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("gobra: connection cannot be hijacked")
+	}
+	return h.Hijack()
+}
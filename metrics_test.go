@@ -0,0 +1,80 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestMetrics(t *testing.T) {
+	store := gobra.NewMemoryStore()
+	store.Put(gobra.Job{ID: "old", Commands: []string{"app", "removed"}, Status: gobra.JobSucceeded, Start: time.Now()})
+	ts := newServer(t, &gobra.Server{JobStore: store})
+	ts.Run("app/math/add/", nil).ExpectStatus(gobra.JobSucceeded)
+	ts.Run("app/fail", nil).ExpectStatus(gobra.JobFailed)
+	ts.Run("app/nothing", nil).ExpectCode(http.StatusNotFound)
+
+	// A job of a command that is no longer in the tree is re-run.
+	resp, err := ts.Client().Post(ts.URL+"/jobs/old/rerun", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = ts.Client().Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(b)
+	for _, want := range []string{
+		`gobra_jobs_total{command="app/math/add",status="succeeded"} 1`,
+		`gobra_jobs_total{command="app/fail",status="failed"} 1`,
+		`gobra_jobs_total{command="unknown",status="failed"} 1`,
+		`gobra_job_duration_seconds_count{command="app/math/add",status="succeeded"} 1`,
+		`gobra_http_request_duration_seconds_count{code="404",method="POST",route="/app"} 1`,
+		`gobra_queue_depth 0`,
+		`gobra_jobs_running 0`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+	for _, unwanted := range []string{"app/math/add/", "app/nothing", "app/removed"} {
+		if strings.Contains(metrics, `"`+unwanted+`"`) {
+			t.Errorf("metrics contain the label %q", unwanted)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// DefaultOutputLimit is the default number of bytes of output kept for
+// each job.
+const DefaultOutputLimit = 1 << 20
+
+// OutputPolicy decides what happens to the output of a job once it
+// exceeds the output limit of the server.
+type OutputPolicy int
+
+const (
+	// OutputDropOldest keeps the most recent output, discarding the oldest.
+	OutputDropOldest OutputPolicy = iota
+
+	// OutputBuffer keeps the output up to the limit and discards the rest.
+	OutputBuffer
+
+	// OutputSpill writes the output to a temporary file, without a limit,
+	// while the job runs. Once it has finished, the beginning of the output
+	// is stored as with OutputBuffer and the file is removed.
+	OutputSpill
+)
+
+// jobOutput stores the output of a job so that it can be retrieved by
+// clients that did not follow the job over the websocket. Writes never
+// block on clients.
+type jobOutput struct {
+	mu     sync.Mutex
+	policy OutputPolicy
+	limit  int
+	dir    string
+
+	// buf holds the output. Under the OutputDropOldest policy, it is
+	// allowed to grow to twice the limit before old output is discarded,
+	// and only its last limit bytes are returned.
+	buf []byte
+	// dropped is the number of bytes that were discarded.
+	dropped int64
+
+	// path is the file holding the output under the OutputSpill policy,
+	// and file is the open file while the job is running. err is the first
+	// error writing to it; output is discarded after an error.
+	path string
+	file *os.File
+	err  error
+}
+
+// newOutput returns an output buffer with the policy of the server.
+func (s *Server) newOutput() *jobOutput {
+	limit := s.OutputLimit
+	if limit <= 0 {
+		limit = DefaultOutputLimit
+	}
+	return &jobOutput{policy: s.OutputPolicy, limit: limit, dir: s.OutputDir}
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch o.policy {
+	case OutputSpill:
+		if o.path == "" && o.err == nil {
+			if o.file, o.err = ioutil.TempFile(o.dir, "gobra-output"); o.err == nil {
+				o.path = o.file.Name()
+			}
+		}
+		if o.file == nil && o.err == nil {
+			o.err = fmt.Errorf("gobra: output written after the job finished")
+		}
+		if o.err == nil {
+			_, o.err = o.file.Write(p)
+		}
+		if o.err != nil {
+			o.dropped += int64(len(p))
+		}
+	case OutputBuffer:
+		n := len(p)
+		if room := o.limit - len(o.buf); n > room {
+			n = room
+		}
+		o.buf = append(o.buf, p[:n]...)
+		o.dropped += int64(len(p) - n)
+	default:
+		o.buf = append(o.buf, p...)
+		if len(o.buf) > 2*o.limit {
+			over := len(o.buf) - o.limit
+			o.buf = append(o.buf[:0], o.buf[over:]...)
+			o.dropped += int64(over)
+		}
+	}
+	return len(p), nil
+}
+
+// WriteTo writes the stored output to w, noting how much was discarded.
+func (o *jobOutput) WriteTo(w io.Writer) (int64, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var n int64
+	buf, dropped := o.buf, o.dropped
+	if o.policy == OutputDropOldest && len(buf) > o.limit {
+		dropped += int64(len(buf) - o.limit)
+		buf = buf[len(buf)-o.limit:]
+	}
+	if dropped > 0 && o.policy == OutputDropOldest {
+		m, err := fmt.Fprintf(w, "[%d bytes of earlier output were discarded]\n", dropped)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	if o.path != "" {
+		f, err := os.Open(o.path)
+		if err != nil {
+			return n, err
+		}
+		m, err := io.Copy(w, f)
+		f.Close()
+		n += m
+		if err != nil {
+			return n, err
+		}
+	} else {
+		m, err := w.Write(buf)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	if dropped > 0 && o.policy != OutputDropOldest {
+		m, err := fmt.Fprintf(w, "\n[%d bytes of later output were discarded]\n", dropped)
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// close is called once the job has finished. It replaces the spill file,
+// if any, with the beginning of its contents up to the limit, so that no
+// more than that is stored with the job, and removes the file.
+func (o *jobOutput) close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.file != nil {
+		o.file.Close()
+		o.file = nil
+	}
+	if o.path == "" {
+		return
+	}
+	f, err := os.Open(o.path)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = f.Stat(); err == nil {
+			o.buf, err = ioutil.ReadAll(io.LimitReader(f, int64(o.limit)))
+			o.dropped += fi.Size() - int64(len(o.buf))
+		}
+		f.Close()
+	}
+	if err != nil && o.err == nil {
+		o.err = err
+	}
+	os.Remove(o.path)
+	o.path = ""
+}
@@ -0,0 +1,87 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestOutputPolicy(t *testing.T) {
+	text := strings.Repeat("a", 10) + strings.Repeat("b", 10) + strings.Repeat("c", 10)
+	for _, test := range []struct {
+		name   string
+		policy gobra.OutputPolicy
+		want   string
+	}{
+		{"drop oldest", gobra.OutputDropOldest, "[21 bytes of earlier output were discarded]\nccccccccc\n"},
+		{"buffer", gobra.OutputBuffer, "aaaaaaaaaa\n[21 bytes of later output were discarded]\n"},
+		{"spill", gobra.OutputSpill, "aaaaaaaaaa\n[21 bytes of later output were discarded]\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ts := newServer(t, &gobra.Server{OutputPolicy: test.policy, OutputLimit: 10, OutputDir: dir})
+			r := ts.Run("app/echo", url.Values{"text": {text}}).ExpectStatus(gobra.JobSucceeded)
+			if r.Output != test.want {
+				t.Errorf("output = %q, want %q", r.Output, test.want)
+			}
+			// The output is stored with the job, not in a file.
+			entries, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				t.Errorf("%s left in the output directory", e.Name())
+			}
+			stored, err := ts.Gobra.JobStore.Output(r.Job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(stored) != test.want {
+				t.Errorf("stored output = %q, want %q", stored, test.want)
+			}
+		})
+	}
+}
+
+func TestOutputWhileRunning(t *testing.T) {
+	ts := newServer(t, &gobra.Server{OutputPolicy: gobra.OutputSpill, OutputLimit: 10})
+	j := ts.Start("app/progress", nil)
+	ws := ts.Websocket("job=" + j.ID)
+	ws.WaitFor(func(m gobra.Message) bool { return m.Type == gobra.MessageOutput })
+	var out strings.Builder
+	if err := ts.API().Output(context.Background(), j.ID, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "step 1\n") {
+		t.Errorf("output while running = %q", out.String())
+	}
+	ws.Output(j.ID)
+}
@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errJobCanceled is the error of a job that was canceled before it started.
+var errJobCanceled = errors.New("canceled before it started")
+
+// jobQueue holds the jobs waiting to run until they are allowed to by
+// Server.MaxConcurrent and Server.CommandLimits.
+type jobQueue struct {
+	mu      sync.Mutex
+	waiting []*queuedJob
+
+	// running is the number of jobs running, and commands the number
+	// running of each command path.
+	running  int
+	commands map[string]int
+
+	// seq orders jobs with the same priority by arrival.
+	seq int64
+}
+
+// queuedJob is a job waiting in the queue. ready is closed when the job
+// may start or has been canceled.
+type queuedJob struct {
+	job      *job
+	command  string
+	priority int
+	seq      int64
+	ready    chan struct{}
+	canceled bool
+}
+
+// enqueue puts the job in the queue. It must be followed by a call to
+// wait.
+func (s *Server) enqueue(j *job) {
+	q := &queuedJob{
+		job:     j,
+		command: strings.Join(j.Commands, "/"),
+		ready:   make(chan struct{}),
+	}
+	if s.Priority != nil {
+		q.priority = s.Priority(j.Job)
+	}
+	s.queue.mu.Lock()
+	s.queue.seq++
+	q.seq = s.queue.seq
+	s.queue.waiting = append(s.queue.waiting, q)
+	s.schedule()
+	s.queue.mu.Unlock()
+	j.queued = q
+}
+
+// wait blocks until the job may run, and returns errJobCanceled if it is
+// canceled first. Each successful call must be followed by a call to done
+// when the job finishes.
+func (s *Server) wait(j *job) error {
+	q := j.queued
+	<-q.ready
+	if q.canceled {
+		return errJobCanceled
+	}
+	return nil
+}
+
+// done makes room in the queue for another job after j has run.
+func (s *Server) done(j *job) {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	s.queue.running--
+	s.queue.commands[strings.Join(j.Commands, "/")]--
+	s.schedule()
+}
+
+// maxConcurrent returns the maximum number of jobs that run at the same
+// time, or a negative number if there is no limit.
+func (s *Server) maxConcurrent() int {
+	if s.MaxConcurrent == 0 {
+		return 1
+	}
+	return s.MaxConcurrent
+}
+
+// cancel removes the job with the given ID from the queue. It reports
+// false if the job is not waiting.
+func (s *Server) cancel(id string) bool {
+	s.queue.mu.Lock()
+	defer s.queue.mu.Unlock()
+	for i, q := range s.queue.waiting {
+		if q.job.ID == id {
+			s.queue.waiting = append(s.queue.waiting[:i], s.queue.waiting[i+1:]...)
+			q.canceled = true
+			close(q.ready)
+			s.schedule()
+			return true
+		}
+	}
+	return false
+}
+
+// schedule starts the waiting jobs that are allowed to run, in order of
+// priority and then of arrival, and tells the others their new position
+// in the queue. It must be called with s.queue.mu held.
+func (s *Server) schedule() {
+	if s.queue.commands == nil {
+		s.queue.commands = make(map[string]int)
+	}
+	waiting := s.queue.waiting
+	sort.SliceStable(waiting, func(i, k int) bool {
+		if waiting[i].priority != waiting[k].priority {
+			return waiting[i].priority > waiting[k].priority
+		}
+		return waiting[i].seq < waiting[k].seq
+	})
+	s.queue.waiting = waiting[:0]
+	for _, q := range waiting {
+		if max := s.maxConcurrent(); max > 0 && s.queue.running >= max {
+			s.queue.waiting = append(s.queue.waiting, q)
+			continue
+		}
+		if n, ok := s.CommandLimits[q.command]; ok && s.queue.commands[q.command] >= n {
+			// Let other commands go ahead rather than waiting behind it.
+			s.queue.waiting = append(s.queue.waiting, q)
+			continue
+		}
+		s.queue.running++
+		s.queue.commands[q.command]++
+		close(q.ready)
+	}
+	for i, q := range s.queue.waiting {
+		position := i + 1
+		var changed bool
+		s.jobs.update(func() {
+			changed = q.job.Position != position
+			q.job.Position = position
+		})
+		if changed {
+			s.send(Message{Type: MessageJobQueued, JobID: q.job.ID, Position: position})
+		}
+	}
+}
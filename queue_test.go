@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestQueue(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		Priority: func(j gobra.Job) int {
+			if j.Flags.Get("text") == "urgent" {
+				return 1
+			}
+			return 0
+		},
+	})
+	sleep := ts.Start("app/sleep", url.Values{"duration": {"200ms"}})
+	normal := ts.Start("app/echo", url.Values{"text": {"normal"}})
+	canceled := ts.Start("app/echo", url.Values{"text": {"canceled"}})
+	urgent := ts.Start("app/echo", url.Values{"text": {"urgent"}})
+
+	// One job runs at a time by default.
+	for _, j := range []*gobra.Job{normal, canceled, urgent} {
+		if j.Status != gobra.JobQueued || j.Position == 0 {
+			t.Errorf("job %s: status = %s, position %d; want it waiting in the queue", j.Flags.Get("text"), j.Status, j.Position)
+		}
+	}
+	if err := ts.API().Cancel(context.Background(), canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if j := ts.Wait(canceled.ID); j.Status != gobra.JobCanceled {
+		t.Errorf("canceled job: status = %s", j.Status)
+	}
+
+	ts.Wait(sleep.ID)
+	normal, urgent = ts.Wait(normal.ID), ts.Wait(urgent.ID)
+	if normal.Status != gobra.JobSucceeded || urgent.Status != gobra.JobSucceeded {
+		t.Fatalf("status = %s and %s, want %s", normal.Status, urgent.Status, gobra.JobSucceeded)
+	}
+	if !urgent.End.Before(normal.Start) {
+		t.Errorf("urgent job ran %v-%v, after the other job %v-%v",
+			urgent.Start, urgent.End, normal.Start, normal.End)
+	}
+}
+
+func TestCommandLimits(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		MaxConcurrent: -1,
+		CommandLimits: map[string]int{"app/sleep": 1},
+	})
+	// Extra slashes do not get around the limit.
+	first := ts.Start("app/sleep/", url.Values{"duration": {"100ms"}})
+	second := ts.Start("app//sleep", url.Values{"duration": {"10ms"}})
+	other := ts.Start("app/echo", url.Values{"text": {"hi"}})
+	if second.Position != 1 {
+		t.Errorf("second sleep job: queue position = %d, want 1", second.Position)
+	}
+	if other.Position != 0 {
+		t.Errorf("other command was queued behind the limited command")
+	}
+	for _, j := range []*gobra.Job{first, second, other} {
+		if j := ts.Wait(j.ID); j.Status != gobra.JobSucceeded {
+			t.Errorf("job %s: status = %s, error %q", j.ID, j.Status, j.Error)
+		}
+		if got := strings.Join(j.Commands, "/"); got != "app/sleep" && got != "app/echo" {
+			t.Errorf("job commands = %q", got)
+		}
+	}
+}
+
+func TestCommandPath(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	r := ts.Run("app/math/add/", nil).ExpectStatus(gobra.JobSucceeded)
+	if got := strings.Join(r.Job.Commands, "/"); got != "app/math/add" {
+		t.Errorf("job commands = %q, want app/math/add", got)
+	}
+	for _, p := range []string{"app/math/add/extra", "app/nothing", "apple/math/add"} {
+		ts.Run(p, nil).ExpectCode(http.StatusNotFound)
+	}
+}
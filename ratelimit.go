@@ -0,0 +1,136 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a token bucket limit on the requests of each client. The
+// zero value means no limit.
+type RateLimit struct {
+	// Rate is the number of requests per second allowed on average.
+	Rate float64
+
+	// Burst is the number of requests allowed at once. It is at least 1.
+	Burst int
+}
+
+// limiters holds the token buckets of the clients, keyed by the scope of
+// the limit and the client.
+type limiters struct {
+	mu        sync.Mutex
+	buckets   map[limiterKey]*rate.Limiter
+	lastPrune time.Time
+}
+
+type limiterKey struct {
+	scope, client string
+}
+
+// reserve takes a token from the bucket of client in the given scope and
+// returns the reservation.
+func (l *limiters) reserve(scope, client string, limit RateLimit, now time.Time) *rate.Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[limiterKey]*rate.Limiter)
+	}
+	if now.Sub(l.lastPrune) > time.Minute {
+		// Forget the buckets that have filled up again, which behave
+		// like new ones.
+		for k, b := range l.buckets {
+			if b.TokensAt(now) >= float64(b.Burst()) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPrune = now
+	}
+	k := limiterKey{scope, client}
+	b, ok := l.buckets[k]
+	if !ok {
+		burst := limit.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		b = rate.NewLimiter(rate.Limit(limit.Rate), burst)
+		l.buckets[k] = b
+	}
+	return b.ReserveN(now, 1)
+}
+
+// allow applies Server.RateLimit and, if command is not empty, the
+// limit in Server.CommandRateLimits for the command path to the client
+// making the request. If the client has made too many requests, it
+// responds with status 429 and a Retry-After header and returns false.
+// Clients are identified by the user name returned by Server.User, if
+// it is set, since the default user name is not authenticated and could
+// be changed with every request.
+func (s *Server) allow(w http.ResponseWriter, r *http.Request, command string) bool {
+	var client string
+	if s.User != nil {
+		client = s.User(r)
+	}
+	if client == "" {
+		client = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			client = host
+		}
+	}
+	now := time.Now()
+	var reservations []*rate.Reservation
+	var delay time.Duration
+	take := func(scope string, limit RateLimit) {
+		if limit.Rate <= 0 {
+			return
+		}
+		res := s.limiters.reserve(scope, client, limit, now)
+		reservations = append(reservations, res)
+		if d := res.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	take("", s.RateLimit)
+	if command != "" {
+		take(command, s.CommandRateLimits[command])
+	}
+	if delay == 0 {
+		return true
+	}
+	// Give the tokens back, since the request is not served.
+	for _, res := range reservations {
+		res.CancelAt(now)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	http.Error(w, "too many requests", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,121 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/client"
+	"github.com/ctessum/gobra/gobratest"
+)
+
+// runAs runs the command at path as the given user, with HTTP basic
+// authentication, and returns the response.
+func runAs(t *testing.T, ts *gobratest.Server, user, path string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/"+path, strings.NewReader(url.Values{}.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(user, "password")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// basicAuthUser returns the basic authentication user name of r, as an
+// authenticating Server.User would.
+func basicAuthUser(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		RateLimit:         gobra.RateLimit{Rate: 0.01, Burst: 3},
+		CommandRateLimits: map[string]gobra.RateLimit{"app/math/add": {Rate: 0.01, Burst: 1}},
+		User:              basicAuthUser,
+	})
+	for i, test := range []struct {
+		user, path string
+		code       int
+	}{
+		{"ann", "app/math/add", http.StatusOK},
+		// The command path is resolved before the limit is applied.
+		{"ann", "app/math/add/", http.StatusTooManyRequests},
+		{"ann", "app/echo", http.StatusOK},
+		{"ann", "app/echo", http.StatusOK},
+		{"ann", "app/echo", http.StatusTooManyRequests},
+		// Each user has their own buckets.
+		{"bob", "app/math/add", http.StatusOK},
+		{"bob", "app/echo", http.StatusOK},
+	} {
+		resp := runAs(t, ts, test.user, test.path)
+		if resp.StatusCode != test.code {
+			t.Errorf("%d: %s %s: status code = %d, want %d", i, test.user, test.path, resp.StatusCode, test.code)
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			continue
+		}
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err != nil || s < 1 || s > 100 {
+			t.Errorf("%d: Retry-After = %q", i, resp.Header.Get("Retry-After"))
+		}
+	}
+
+	// Clients without a user name are told apart by their address, and
+	// uploads count towards RateLimit.
+	for i := 0; i < 3; i++ {
+		ts.Upload("path", "testdata/input.txt")
+	}
+	_, err := ts.API().Upload(context.Background(), "path", "testdata/input.txt")
+	if e, ok := err.(*client.Error); !ok || e.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("fourth upload: error = %v, want status 429", err)
+	}
+}
+
+func TestRateLimitUnauthenticated(t *testing.T) {
+	// Without Server.User, the user name is not authenticated, so clients
+	// cannot get new buckets by claiming other names.
+	ts := newServer(t, &gobra.Server{RateLimit: gobra.RateLimit{Rate: 0.001, Burst: 1}})
+	for i, user := range []string{"ann", "bob", "carol"} {
+		want := http.StatusTooManyRequests
+		if i == 0 {
+			want = http.StatusOK
+		}
+		if resp := runAs(t, ts, user, "app/echo"); resp.StatusCode != want {
+			t.Errorf("%s: status code = %d, want %d", user, resp.StatusCode, want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ctessum/gobra/api"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// maxCommandRequestSize is the maximum size of a JSON command request.
+const maxCommandRequestSize = 32 << 20
+
+// These types are JSON command requests and their errors; see package api.
+type (
+	CommandRequest = api.CommandRequest
+	FieldError     = api.FieldError
+)
+
+// isJSONRequest reports whether the body of r is JSON.
+func isJSONRequest(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == "application/json"
+}
+
+// decodeCommandRequest reads a CommandRequest for the given commands
+// from r and converts its flags to the form in which they are stored in
+// a Job.
+func (s *Server) decodeCommandRequest(w http.ResponseWriter, r *http.Request, cmds []string) (url.Values, []string, *FieldError) {
+	var req CommandRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCommandRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return nil, nil, &FieldError{Message: fmt.Sprintf("invalid JSON request: %v", err)}
+	}
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return nil, nil, &FieldError{Message: "invalid JSON request: unexpected data after the request"}
+	}
+	types := s.commandFlags[strings.Join(cmds, "/")]
+	flags := make(url.Values, len(req.Flags))
+	for name, raw := range req.Flags {
+		t, ok := types[name]
+		if !ok || name == "help" {
+			return nil, nil, &FieldError{Field: "flags." + name, Message: "unknown flag"}
+		}
+		v, err := flagValue(t, raw)
+		if err != nil && s.isSensitiveFlag(name) {
+			return nil, nil, &FieldError{Field: "flags." + name, Message: "invalid value"}
+		} else if err != nil {
+			return nil, nil, &FieldError{Field: "flags." + name, Message: err.Error()}
+		}
+		flags.Set(name, v)
+	}
+	return flags, req.Args, nil
+}
+
+// flagInfo is the type of a flag, which is all that is needed to check
+// its JSON values.
+type flagInfo struct {
+	typ   string
+	slice bool
+}
+
+// flagInfos returns the types of the flags that c accepts, including
+// those it inherits. Looking up inherited flags modifies c, so it must
+// not be called while a command runs.
+func flagInfos(c *cobra.Command) map[string]flagInfo {
+	types := make(map[string]flagInfo)
+	add := func(f *pflag.Flag) {
+		_, slice := f.Value.(pflag.SliceValue)
+		types[f.Name] = flagInfo{typ: f.Value.Type(), slice: slice}
+	}
+	c.LocalFlags().VisitAll(add)
+	c.InheritedFlags().VisitAll(add)
+	return types
+}
+
+// flagValue converts the JSON value of a flag of type t to the string
+// that the flag is set to.
+func flagValue(t flagInfo, raw json.RawMessage) (string, error) {
+	typ := t.typ
+	if t.slice {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return "", fmt.Errorf("expected an array for %s flag", typ)
+		}
+		vals := make([]string, len(elems))
+		for i, e := range elems {
+			v, err := scalarValue(sliceElemType(typ), e)
+			if err != nil {
+				return "", fmt.Errorf("element %d: %v", i, err)
+			}
+			vals[i] = v
+		}
+		b, err := writeAsCSV(vals)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+	return scalarValue(typ, raw)
+}
+
+// sliceElemType returns the type of the elements of a slice flag type,
+// such as "int" for "intSlice".
+func sliceElemType(typ string) string {
+	for _, suffix := range []string{"Slice", "Array"} {
+		if strings.HasSuffix(typ, suffix) {
+			return strings.TrimSuffix(typ, suffix)
+		}
+	}
+	return "string"
+}
+
+// scalarValue converts the JSON value of a flag of type typ to a string,
+// checking that booleans and numbers have the right JSON type.
+func scalarValue(typ string, raw json.RawMessage) (string, error) {
+	switch {
+	case typ == "bool":
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return "", fmt.Errorf("expected a boolean")
+		}
+		return strconv.FormatBool(b), nil
+	case typ == "count", strings.HasPrefix(typ, "int"), strings.HasPrefix(typ, "uint"), strings.HasPrefix(typ, "float"):
+		var n json.Number
+		if err := json.Unmarshal(raw, &n); err != nil || bytes.HasPrefix(bytes.TrimSpace(raw), []byte(`"`)) {
+			return "", fmt.Errorf("expected a number")
+		}
+		var err error
+		switch {
+		case typ == "count", strings.HasPrefix(typ, "int"):
+			_, err = strconv.ParseInt(n.String(), 10, 64)
+		case strings.HasPrefix(typ, "uint"):
+			_, err = strconv.ParseUint(n.String(), 10, 64)
+		default:
+			_, err = strconv.ParseFloat(n.String(), 64)
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid %s %s", typ, n)
+		}
+		return n.String(), nil
+	default:
+		var str string
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return "", fmt.Errorf("expected a string")
+		}
+		return str, nil
+	}
+}
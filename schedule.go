@@ -0,0 +1,389 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ErrScheduleNotFound is returned when there is no schedule with the
+// requested ID.
+var ErrScheduleNotFound = errors.New("gobra: schedule not found")
+
+// Schedule runs a command with fixed flags at the times given by a cron
+// expression. The jobs it starts are recorded in the history like any
+// other, with their Schedule field set to the ID of the schedule.
+type Schedule struct {
+	ID string `json:"id"`
+
+	// Spec is the cron expression, e.g. "0 2 * * *" for 2am every day, or
+	// a descriptor such as "@daily" or "@every 1h".
+	Spec string `json:"spec"`
+
+	// Commands is the command path, starting with the root command name,
+	// and Flags are the flag values it is run with.
+	Commands []string   `json:"commands"`
+	Flags    url.Values `json:"flags"`
+
+	// Paused schedules do not start jobs until they are resumed.
+	Paused bool `json:"paused"`
+
+	// Next is the next time the schedule runs, if it is not paused.
+	Next time.Time `json:"next,omitempty"`
+
+	// LastRun is the last time the schedule ran and LastJob is the ID of
+	// the job it started then.
+	LastRun time.Time `json:"lastRun,omitempty"`
+	LastJob string    `json:"lastJob,omitempty"`
+
+	// entry identifies the schedule in the cron scheduler while it is
+	// not paused.
+	entry cron.EntryID
+
+	// stored schedules were added over HTTP and are saved in the job
+	// store if it is a ScheduleStore.
+	stored bool
+}
+
+// ScheduleStore is implemented by job stores that also keep schedules,
+// as BoltStore does. Schedules added over HTTP are saved in the JobStore
+// of a server if it is a ScheduleStore, and are added back when the
+// server starts. Schedules added with Server.AddSchedule are not saved,
+// as the program adds them again every time it starts.
+type ScheduleStore interface {
+	// PutSchedule creates or replaces a schedule.
+	PutSchedule(sc Schedule) error
+
+	// DeleteSchedule removes the schedule with the given ID.
+	DeleteSchedule(id string) error
+
+	// Schedules returns the schedules in the order they were first put.
+	Schedules() ([]Schedule, error)
+}
+
+// AddSchedule runs the given commands with the given flags at the times
+// given by spec, a cron expression with five fields (minute, hour, day of
+// month, month and day of week) or a descriptor such as "@daily". The
+// commands start with the root command name, as in Job.Commands.
+func (s *Server) AddSchedule(spec string, commands []string, flags url.Values) (Schedule, error) {
+	return s.addSchedule(spec, commands, flags, false)
+}
+
+// addSchedule adds a schedule, saving it in the job store if stored is
+// true.
+func (s *Server) addSchedule(spec string, commands []string, flags url.Values, stored bool) (Schedule, error) {
+	if len(commands) == 0 || commands[0] != s.Root.Name() {
+		return Schedule{}, fmt.Errorf("gobra: schedule commands must start with %q", s.Root.Name())
+	}
+	commands, err := s.commandPath(commands)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("gobra: invalid schedule commands: %v", err)
+	}
+	id, err := newID()
+	if err != nil {
+		return Schedule{}, err
+	}
+	sc := &Schedule{ID: id, Spec: spec, Commands: commands, Flags: flags, stored: stored}
+	if sc.Flags == nil {
+		sc.Flags = make(url.Values)
+	}
+
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	if err := s.startSchedule(sc); err != nil {
+		return Schedule{}, err
+	}
+	if err := s.saveSchedule(sc); err != nil {
+		s.cronScheduler().Remove(sc.entry)
+		return Schedule{}, err
+	}
+	s.schedules = append(s.schedules, sc)
+	return s.scheduleInfo(sc), nil
+}
+
+// Schedules returns the schedules, in the order they were added.
+func (s *Server) Schedules() []Schedule {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	schedules := make([]Schedule, len(s.schedules))
+	for i, sc := range s.schedules {
+		schedules[i] = s.scheduleInfo(sc)
+	}
+	return schedules
+}
+
+// PauseSchedule stops the schedule with the given ID from starting jobs
+// until it is resumed. Jobs that it already started are not affected.
+func (s *Server) PauseSchedule(id string) error {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	sc, _ := s.findSchedule(id)
+	if sc == nil {
+		return ErrScheduleNotFound
+	}
+	if !sc.Paused {
+		s.cronScheduler().Remove(sc.entry)
+		sc.Paused = true
+	}
+	return s.saveSchedule(sc)
+}
+
+// ResumeSchedule resumes the paused schedule with the given ID.
+func (s *Server) ResumeSchedule(id string) error {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	sc, _ := s.findSchedule(id)
+	if sc == nil {
+		return ErrScheduleNotFound
+	}
+	if sc.Paused {
+		if err := s.startSchedule(sc); err != nil {
+			return err
+		}
+		sc.Paused = false
+	}
+	return s.saveSchedule(sc)
+}
+
+// RemoveSchedule removes the schedule with the given ID.
+func (s *Server) RemoveSchedule(id string) error {
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	sc, i := s.findSchedule(id)
+	if sc == nil {
+		return ErrScheduleNotFound
+	}
+	if store, ok := s.JobStore.(ScheduleStore); ok && sc.stored {
+		if err := store.DeleteSchedule(id); err != nil {
+			return fmt.Errorf("gobra: deleting schedule: %v", err)
+		}
+	}
+	if !sc.Paused {
+		s.cronScheduler().Remove(sc.entry)
+	}
+	s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+	return nil
+}
+
+// cronScheduler returns the scheduler of the server, creating it if
+// needed. It must be called with s.schedulesMu held.
+func (s *Server) cronScheduler() *cron.Cron {
+	if s.cron == nil {
+		s.cron = cron.New()
+	}
+	return s.cron
+}
+
+// startSchedule adds sc to the scheduler. It must be called with
+// s.schedulesMu held.
+func (s *Server) startSchedule(sc *Schedule) error {
+	id := sc.ID
+	entry, err := s.cronScheduler().AddFunc(sc.Spec, func() { s.runSchedule(id) })
+	if err != nil {
+		return fmt.Errorf("gobra: invalid schedule %q: %v", sc.Spec, err)
+	}
+	sc.entry = entry
+	return nil
+}
+
+// saveSchedule saves sc in the job store if it was added over HTTP and
+// the store keeps schedules. It must be called with s.schedulesMu held.
+func (s *Server) saveSchedule(sc *Schedule) error {
+	store, ok := s.JobStore.(ScheduleStore)
+	if !ok || !sc.stored {
+		return nil
+	}
+	if err := store.PutSchedule(*sc); err != nil {
+		return fmt.Errorf("gobra: saving schedule: %v", err)
+	}
+	return nil
+}
+
+// loadSchedules adds the schedules saved in the job store.
+func (s *Server) loadSchedules() error {
+	store, ok := s.JobStore.(ScheduleStore)
+	if !ok {
+		return nil
+	}
+	schedules, err := store.Schedules()
+	if err != nil {
+		return fmt.Errorf("gobra: loading schedules: %v", err)
+	}
+	s.schedulesMu.Lock()
+	defer s.schedulesMu.Unlock()
+	for i := range schedules {
+		sc := &schedules[i]
+		sc.stored = true
+		if !sc.Paused {
+			if err := s.startSchedule(sc); err != nil {
+				return err
+			}
+		}
+		s.schedules = append(s.schedules, sc)
+	}
+	return nil
+}
+
+// findSchedule returns the schedule with the given ID and its index, or nil
+// if there is none. It must be called with s.schedulesMu held.
+func (s *Server) findSchedule(id string) (*Schedule, int) {
+	for i, sc := range s.schedules {
+		if sc.ID == id {
+			return sc, i
+		}
+	}
+	return nil, -1
+}
+
+// scheduleInfo returns a copy of sc with its next run time and the
+// values of sensitive flags redacted. It must be called with
+// s.schedulesMu held.
+func (s *Server) scheduleInfo(sc *Schedule) Schedule {
+	info := *sc
+	info.Flags = s.redactFlags(sc.Flags)
+	if !sc.Paused {
+		info.Next = s.cronScheduler().Entry(sc.entry).Next
+	}
+	return info
+}
+
+// runSchedule starts a job for the schedule with the given ID, going
+// through PreRun and the queue like a job started by a request.
+func (s *Server) runSchedule(id string) {
+	s.schedulesMu.Lock()
+	sc, _ := s.findSchedule(id)
+	if sc == nil || sc.Paused {
+		s.schedulesMu.Unlock()
+		return
+	}
+	cmds := append([]string(nil), sc.Commands...)
+	flags := make(url.Values, len(sc.Flags))
+	for k, v := range sc.Flags {
+		flags[k] = append([]string(nil), v...)
+	}
+	sc.LastRun = time.Now()
+	s.schedulesMu.Unlock()
+
+	if s.PreRun != nil {
+		if err := s.PreRun(&cmds, &flags); err != nil {
+			s.logger().Error("running pre-run hook", "schedule", id, "error", err)
+			return
+		}
+		var err error
+		if cmds, err = s.commandPath(cmds); err != nil {
+			s.logger().Error("running pre-run hook", "schedule", id, "error", err)
+			return
+		}
+	}
+	j, err := s.newJob(cmds, flags, nil, "", "")
+	if err != nil {
+		s.logger().Error("starting scheduled job", "schedule", id, "error", err)
+		return
+	}
+	s.jobs.update(func() { j.Schedule = id })
+
+	s.schedulesMu.Lock()
+	if sc, _ := s.findSchedule(id); sc != nil {
+		sc.LastJob = j.ID
+		if err := s.saveSchedule(sc); err != nil {
+			s.logger().Warn("saving schedule", "schedule", id, "error", err)
+		}
+	}
+	s.schedulesMu.Unlock()
+
+	s.run(j)
+}
+
+// schedulesHandler manages schedules:
+//
+//	GET /schedules                 lists the schedules.
+//	POST /schedules                adds the schedule given as JSON with its
+//	                               spec, commands and flags.
+//	GET /schedules/<id>            returns the schedule.
+//	DELETE /schedules/<id>         removes the schedule.
+//	POST /schedules/<id>/pause     pauses the schedule.
+//	POST /schedules/<id>/resume    resumes the schedule.
+func (s *Server) schedulesHandler(w http.ResponseWriter, r *http.Request) {
+	if s.AllowCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+CSRFHeader)
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/schedules"), "/"), "/")
+	switch {
+	case r.Method == http.MethodOptions:
+	case parts[0] == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Schedules())
+	case parts[0] == "" && r.Method == http.MethodPost:
+		var sc Schedule
+		if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+			http.Error(w, fmt.Sprintf("invalid schedule: %v", err), http.StatusBadRequest)
+			return
+		}
+		sc, err := s.addSchedule(sc.Spec, sc.Commands, sc.Flags, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Location", "/schedules/"+sc.ID)
+		writeJSON(w, http.StatusCreated, sc)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		for _, sc := range s.Schedules() {
+			if sc.ID == parts[0] {
+				writeJSON(w, http.StatusOK, sc)
+				return
+			}
+		}
+		http.Error(w, "schedule not found", http.StatusNotFound)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		s.writeScheduleResult(w, s.RemoveSchedule(parts[0]))
+	case len(parts) == 2 && parts[1] == "pause" && r.Method == http.MethodPost:
+		s.writeScheduleResult(w, s.PauseSchedule(parts[0]))
+	case len(parts) == 2 && parts[1] == "resume" && r.Method == http.MethodPost:
+		s.writeScheduleResult(w, s.ResumeSchedule(parts[0]))
+	default:
+		http.Error(w, "404 Page not Found", http.StatusNotFound)
+	}
+}
+
+// writeScheduleResult writes the response to a change to a schedule.
+func (s *Server) writeScheduleResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == ErrScheduleNotFound:
+		http.Error(w, "schedule not found", http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/gobratest"
+)
+
+// do sends a request with the given method and JSON body to the server,
+// checks the status code of the response and decodes it into v if it is
+// not nil.
+func do(t *testing.T, ts *gobratest.Server, method, path string, body interface{}, code int, v interface{}) {
+	t.Helper()
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s: status code = %d, want %d", method, path, resp.StatusCode, code)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	sc, err := ts.Gobra.AddSchedule("@every 1s", []string{"app", "echo"}, url.Values{"text": {"scheduled"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Next.IsZero() {
+		t.Error("schedule has no next run")
+	}
+	deadline := time.Now().Add(gobratest.Timeout)
+	for sc.LastJob == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		sc = ts.Gobra.Schedules()[0]
+	}
+	if sc.LastJob == "" {
+		t.Fatal("schedule did not run")
+	}
+	j := ts.Wait(sc.LastJob)
+	if j.Status != gobra.JobSucceeded || j.Schedule != sc.ID {
+		t.Errorf("scheduled job = %+v", j)
+	}
+
+	if _, err := ts.Gobra.AddSchedule("not a spec", []string{"app", "echo"}, nil); err == nil {
+		t.Error("invalid spec accepted")
+	}
+	if _, err := ts.Gobra.AddSchedule("@daily", []string{"app", "nothing"}, nil); err == nil {
+		t.Error("invalid commands accepted")
+	}
+}
+
+func TestSchedulePreRun(t *testing.T) {
+	// The commands changed by PreRun are resolved as for requests.
+	ts := newServer(t, &gobra.Server{PreRun: func(cmds *[]string, flags *url.Values) error {
+		*cmds = append(*cmds, "")
+		return nil
+	}})
+	sc, err := ts.Gobra.AddSchedule("@every 1s", []string{"app", "echo"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(gobratest.Timeout)
+	for sc.LastJob == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		sc = ts.Gobra.Schedules()[0]
+	}
+	if sc.LastJob == "" {
+		t.Fatal("schedule did not run")
+	}
+	j := ts.Wait(sc.LastJob)
+	if got := strings.Join(j.Commands, "/"); got != "app/echo" || j.Status != gobra.JobSucceeded {
+		t.Errorf("scheduled job = %+v, want commands app/echo", j)
+	}
+}
+
+func TestSchedulesAPI(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	var sc gobra.Schedule
+	do(t, ts, http.MethodPost, "/schedules", gobra.Schedule{Spec: "@daily", Commands: []string{"app", "math", "add"}}, http.StatusCreated, &sc)
+	do(t, ts, http.MethodPost, "/schedules", gobra.Schedule{Spec: "@daily", Commands: []string{"app", "math", "nothing"}}, http.StatusBadRequest, nil)
+	do(t, ts, http.MethodPost, "/schedules/"+sc.ID+"/pause", nil, http.StatusNoContent, nil)
+	var got gobra.Schedule
+	do(t, ts, http.MethodGet, "/schedules/"+sc.ID, nil, http.StatusOK, &got)
+	if !got.Paused || !got.Next.IsZero() {
+		t.Errorf("paused schedule = %+v", got)
+	}
+	do(t, ts, http.MethodPost, "/schedules/"+sc.ID+"/resume", nil, http.StatusNoContent, nil)
+	do(t, ts, http.MethodDelete, "/schedules/"+sc.ID, nil, http.StatusNoContent, nil)
+	do(t, ts, http.MethodDelete, "/schedules/"+sc.ID, nil, http.StatusNotFound, nil)
+	var all []gobra.Schedule
+	do(t, ts, http.MethodGet, "/schedules", nil, http.StatusOK, &all)
+	if len(all) != 0 {
+		t.Errorf("schedules = %+v", all)
+	}
+}
+
+func TestSchedulesSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	restart := func(ts *gobratest.Server) *gobratest.Server {
+		if ts != nil {
+			ts.Close()
+			ts.Gobra.JobStore.(*gobra.BoltStore).Close()
+		}
+		store, err := gobra.OpenBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newServer(t, &gobra.Server{JobStore: store})
+	}
+	ts := restart(nil)
+	if _, err := ts.Gobra.AddSchedule("@daily", []string{"app", "echo"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var first, second gobra.Schedule
+	do(t, ts, http.MethodPost, "/schedules", gobra.Schedule{Spec: "@hourly", Commands: []string{"app", "echo"}, Flags: url.Values{"text": {"1"}}}, http.StatusCreated, &first)
+	do(t, ts, http.MethodPost, "/schedules", gobra.Schedule{Spec: "@weekly", Commands: []string{"app", "math", "add"}}, http.StatusCreated, &second)
+	do(t, ts, http.MethodPost, "/schedules/"+second.ID+"/pause", nil, http.StatusNoContent, nil)
+
+	// Only the schedules added over HTTP come back.
+	ts = restart(ts)
+	schedules := ts.Gobra.Schedules()
+	if len(schedules) != 2 {
+		t.Fatalf("schedules = %+v", schedules)
+	}
+	if sc := schedules[0]; sc.ID != first.ID || sc.Spec != "@hourly" || sc.Flags.Get("text") != "1" || sc.Paused || sc.Next.IsZero() {
+		t.Errorf("first schedule = %+v", sc)
+	}
+	if sc := schedules[1]; sc.ID != second.ID || strings.Join(sc.Commands, "/") != "app/math/add" || !sc.Paused {
+		t.Errorf("second schedule = %+v", sc)
+	}
+
+	do(t, ts, http.MethodDelete, "/schedules/"+first.ID, nil, http.StatusNoContent, nil)
+	ts = restart(ts)
+	if schedules := ts.Gobra.Schedules(); len(schedules) != 1 || schedules[0].ID != second.ID {
+		t.Errorf("schedules = %+v", schedules)
+	}
+	ts.Close()
+	ts.Gobra.JobStore.(*gobra.BoltStore).Close()
+}
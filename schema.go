@@ -0,0 +1,92 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"net/http"
+
+	"github.com/ctessum/gobra/api"
+	"github.com/spf13/cobra"
+)
+
+// These types describe the command tree served at /schema; see package api.
+type (
+	CommandSchema = api.CommandSchema
+	FlagSchema    = api.FlagSchema
+)
+
+// Schema returns the schema of the command tree of the server. Once the
+// server has started, this is the schema of the tree when it started.
+func (s *Server) Schema() CommandSchema {
+	if s.schema != nil {
+		return *s.schema
+	}
+	return s.commandSchema(s.Root)
+}
+
+func (s *Server) commandSchema(c *cobra.Command) CommandSchema {
+	cs := CommandSchema{
+		Name:  c.Name(),
+		Use:   c.Use,
+		Short: c.Short,
+		Long:  c.Long,
+
+		Runnable: c.Runnable(),
+	}
+	persistent := c.PersistentFlags()
+	for _, f := range flagSetToSlice(persistent, c.LocalNonPersistentFlags()) {
+		fs := FlagSchema{
+			Name:       f.Name,
+			Shorthand:  f.Shorthand,
+			Type:       f.Type,
+			Usage:      f.Usage,
+			Default:    f.DefValue,
+			Persistent: persistent.Lookup(f.Name) == f.Flag,
+			Uploadable: s.canUploadFile(f.Name),
+			Archive:    s.isArchiveFlag(f.Name),
+			Output:     s.isOutputFlag(f.Name),
+			Sensitive:  s.isSensitiveFlag(f.Name),
+		}
+		if fs.Sensitive {
+			fs.Default = ""
+		}
+		cs.Flags = append(cs.Flags, fs)
+	}
+	for _, sub := range c.Commands() {
+		if sub.Hidden || !notHelpCommand(sub.Use) {
+			continue
+		}
+		cs.Commands = append(cs.Commands, s.commandSchema(sub))
+	}
+	return cs
+}
+
+// schemaHandler serves the schema of the command tree as JSON.
+func (s *Server) schemaHandler(w http.ResponseWriter, r *http.Request) {
+	if s.AllowCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	writeJSON(w, http.StatusOK, s.Schema())
+}
@@ -0,0 +1,67 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import "net/url"
+
+// redacted replaces the values of sensitive flags.
+const redacted = "********"
+
+// MakeFlagSensitive registers the given flag name(s) as sensitive, such
+// as flags holding passwords or API keys. Their values are entered in
+// password inputs without their default being shown, and are replaced by
+// "********" in logs, the job history, audit records, webhooks and
+// schedules. Because their values are not kept, re-running a job uses
+// the default value of its sensitive flags.
+func (s *Server) MakeFlagSensitive(names ...string) {
+	if s.sensitiveFlags == nil {
+		s.sensitiveFlags = make(map[string]struct{})
+	}
+	for _, name := range names {
+		s.sensitiveFlags[name] = struct{}{}
+	}
+}
+
+func (s *Server) isSensitiveFlag(name string) bool {
+	_, ok := s.sensitiveFlags[name]
+	return ok
+}
+
+// redactFlags returns a copy of flags with the values of sensitive flags
+// replaced.
+func (s *Server) redactFlags(flags url.Values) url.Values {
+	if flags == nil {
+		return nil
+	}
+	out := make(url.Values, len(flags))
+	for k, v := range flags {
+		if s.isSensitiveFlag(k) {
+			out[k] = []string{redacted}
+		} else {
+			out[k] = append([]string(nil), v...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,151 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/gobratest"
+	"github.com/spf13/cobra"
+)
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestSensitiveFlags(t *testing.T) {
+	root := &cobra.Command{Use: "app"}
+	var pin int
+	login := &cobra.Command{Use: "login", Run: func(cmd *cobra.Command, args []string) {
+		if pin == 0 {
+			cmd.Println("no pin")
+		} else {
+			cmd.Println("pin has", len(strconv.Itoa(pin)), "digits")
+		}
+	}}
+	login.Flags().IntVar(&pin, "pin", 0, "PIN")
+	root.AddCommand(login)
+
+	var logs, audit syncBuffer
+	hook := newWebhook(t)
+	s := &gobra.Server{
+		Root:          root,
+		Logger:        slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		AuditLogger:   slog.New(slog.NewJSONHandler(&audit, nil)),
+		Webhooks:      []string{hook.URL},
+		WebhookSecret: "secret",
+	}
+	s.MakeFlagSensitive("pin")
+	ts := gobratest.NewServer(t, s)
+	ws := ts.Websocket("")
+
+	r := ts.Run("app/login", url.Values{"pin": {"86420531"}}).ExpectStatus(gobra.JobSucceeded).ExpectOutput("pin has 8 digits")
+	if got := r.Job.Flags.Get("pin"); got != "********" {
+		t.Errorf("pin in job = %q", got)
+	}
+	hook.wait()
+	// A failed job does not show the value in its error.
+	failed := ts.Run("app/login", url.Values{"pin": {"hunter2"}}).ExpectStatus(gobra.JobFailed)
+	if !strings.Contains(failed.Job.Error, "--pin") {
+		t.Errorf("job error = %q", failed.Job.Error)
+	}
+	hook.wait()
+	// The next job does not get the PIN of the previous one.
+	ts.Run("app/login", nil).ExpectStatus(gobra.JobSucceeded).ExpectOutput("no pin")
+	hook.wait()
+	ws.Output(failed.Job.ID)
+
+	resp, err := ts.Client().Post(ts.URL+"/app/login", "application/json",
+		strings.NewReader(`{"flags": {"pin": 86420531864205318642}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid JSON value: status code = %d", resp.StatusCode)
+	}
+	if _, err := ts.Gobra.AddSchedule("@daily", []string{"app", "login"}, url.Values{"pin": {"86420531"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var history bytes.Buffer
+	for _, p := range []string{"/jobs", "/jobs/" + r.Job.ID, "/jobs/" + failed.Job.ID, "/schedules"} {
+		resp, err := ts.Client().Get(ts.URL + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		history.Write(b)
+	}
+	var messages strings.Builder
+	for _, m := range ws.Messages() {
+		messages.WriteString(m.Data + m.Error)
+	}
+	var payloads strings.Builder
+	hook.mu.Lock()
+	for _, p := range hook.payloads {
+		payloads.WriteString(p.Error + strings.Join(p.Flags["pin"], ","))
+	}
+	hook.mu.Unlock()
+	for name, text := range map[string]string{
+		"logs":     logs.String(),
+		"audit":    audit.String(),
+		"history":  history.String(),
+		"messages": messages.String(),
+		"webhooks": payloads.String(),
+		"response": string(response),
+	} {
+		for _, secret := range []string{"86420531", "hunter2"} {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s contain %q: %s", name, secret, text)
+			}
+		}
+	}
+}
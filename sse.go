@@ -0,0 +1,102 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// eventsHandler streams the messages of a job as server-sent events, for
+// clients that cannot use websockets. Each event holds a Message as JSON,
+// with the sequence number of the message as the event ID. The stream
+// starts after the sequence number given in the Last-Event-ID header or
+// the since query parameter, as with the websocket.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request, job string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	since, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, _ = strconv.ParseInt(v, 10, 64)
+	}
+	sub, backlog, done := s.subscribe(job, since)
+	defer s.broadcaster.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	for _, m := range backlog {
+		if err := writeEvent(w, m); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	if done {
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var m Message
+		select {
+		case msg, ok := <-sub.ch:
+			if !ok {
+				// The client fell behind and has to reconnect.
+				return
+			}
+			m = msg
+		case t := <-heartbeat.C:
+			m = heartbeatMessage(t)
+		case <-r.Context().Done():
+			return
+		}
+		if err := writeEvent(w, m); err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes m as a server-sent event.
+func writeEvent(w http.ResponseWriter, m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if m.Seq > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", m.Seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "data: %s\n\n", b)
+	return err
+}
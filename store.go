@@ -0,0 +1,293 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned by a JobStore when there is no job with the
+// requested ID.
+var ErrJobNotFound = errors.New("gobra: job not found")
+
+// DefaultHistoryLimit is the default number of finished jobs kept in the
+// history.
+const DefaultHistoryLimit = 1000
+
+// historyPruneInterval is how often old jobs are deleted from the history.
+const historyPruneInterval = time.Minute
+
+// memoryOutputJobs is the number of jobs whose output a MemoryStore keeps.
+const memoryOutputJobs = 100
+
+// defaultJobQueryLimit is the maximum number of jobs returned by a query
+// that does not set a limit.
+const defaultJobQueryLimit = 100
+
+// JobStore stores the history of the jobs run by a server.
+// Implementations must be safe for concurrent use.
+type JobStore interface {
+	// Put creates or replaces the record of a job.
+	Put(j Job) error
+
+	// Get returns the job with the given ID, or ErrJobNotFound.
+	Get(id string) (Job, error)
+
+	// List returns the jobs that match q, most recent first.
+	List(q JobQuery) ([]Job, error)
+
+	// PutOutput stores the output of the job with the given ID.
+	PutOutput(id string, output []byte) error
+
+	// Output returns the output of the job with the given ID, or
+	// ErrJobNotFound.
+	Output(id string) ([]byte, error)
+
+	// Delete removes the job with the given ID and its output.
+	Delete(id string) error
+}
+
+// JobQuery selects jobs from a JobStore. Fields left at their zero
+// value match every job.
+type JobQuery struct {
+	// Command is the command path separated by slashes and starting with
+	// the root command, as in the URL of the command, e.g. "app/run".
+	Command string
+
+	Status JobStatus
+	User   string
+
+	// Since selects jobs that started at or after the given time.
+	Since time.Time
+
+	// Limit is the maximum number of jobs returned. If it is zero, at
+	// most 100 jobs are returned.
+	Limit int
+}
+
+// Matches reports whether j is selected by q, ignoring q.Limit.
+func (q JobQuery) Matches(j Job) bool {
+	return (q.Command == "" || q.Command == strings.Join(j.Commands, "/")) &&
+		(q.Status == "" || q.Status == j.Status) &&
+		(q.User == "" || q.User == j.User) &&
+		!j.Start.Before(q.Since)
+}
+
+// limit sorts jobs by start time, most recent first, and applies q.Limit.
+func (q JobQuery) limit(jobs []Job) []Job {
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Start.After(jobs[k].Start) })
+	n := q.Limit
+	if n <= 0 {
+		n = defaultJobQueryLimit
+	}
+	if len(jobs) > n {
+		jobs = jobs[:n]
+	}
+	return jobs
+}
+
+// MemoryStore is a JobStore that keeps jobs in memory, so the history
+// is lost when the server stops. To bound the memory it uses, it keeps
+// the output of only the 100 most recent jobs; older jobs have no output.
+type MemoryStore struct {
+	mu     sync.Mutex
+	jobs   map[string]Job
+	output map[string][]byte
+	// outputOrder holds the IDs of the jobs in output, oldest first.
+	outputOrder []string
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:   make(map[string]Job),
+		output: make(map[string][]byte),
+	}
+}
+
+// Put implements JobStore.
+func (m *MemoryStore) Put(j Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[j.ID] = j
+	return nil
+}
+
+// Get implements JobStore.
+func (m *MemoryStore) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrJobNotFound
+	}
+	return j, nil
+}
+
+// List implements JobStore.
+func (m *MemoryStore) List(q JobQuery) ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []Job
+	for _, j := range m.jobs {
+		if q.Matches(j) {
+			jobs = append(jobs, j)
+		}
+	}
+	return q.limit(jobs), nil
+}
+
+// PutOutput implements JobStore.
+func (m *MemoryStore) PutOutput(id string, output []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.output[id]; !ok {
+		m.outputOrder = append(m.outputOrder, id)
+	}
+	m.output[id] = output
+	if len(m.outputOrder) > memoryOutputJobs {
+		delete(m.output, m.outputOrder[0])
+		m.outputOrder = m.outputOrder[1:]
+	}
+	return nil
+}
+
+// Output implements JobStore.
+func (m *MemoryStore) Output(id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.output[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return b, nil
+}
+
+// Delete implements JobStore.
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+	if _, ok := m.output[id]; ok {
+		delete(m.output, id)
+		for i, o := range m.outputOrder {
+			if o == id {
+				m.outputOrder = append(m.outputOrder[:i], m.outputOrder[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// markInterruptedJobs marks the jobs in the history that were still
+// queued or running when the server stopped as failed.
+func (s *Server) markInterruptedJobs() error {
+	var jobs []Job
+	for _, status := range []JobStatus{JobQueued, JobRunning} {
+		j, err := s.JobStore.List(JobQuery{Status: status, Limit: math.MaxInt32})
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, j...)
+	}
+	for _, j := range jobs {
+		if _, running := s.jobs.get(j.ID); running {
+			continue
+		}
+		j.Status = JobFailed
+		j.Error = "interrupted: the server stopped"
+		if err := s.JobStore.Put(j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maybePruneHistory deletes old jobs from the history if it has not been
+// done recently.
+func (s *Server) maybePruneHistory() {
+	s.lastPruneMu.Lock()
+	due := time.Since(s.lastPrune) > historyPruneInterval
+	if due {
+		s.lastPrune = time.Now()
+	}
+	s.lastPruneMu.Unlock()
+	if !due {
+		return
+	}
+	if err := s.pruneHistory(); err != nil {
+		s.logger().Error("deleting old jobs", "error", err)
+	}
+}
+
+// pruneHistory deletes the finished jobs beyond HistoryLimit or older
+// than HistoryMaxAge from the history, with their output files.
+func (s *Server) pruneHistory() error {
+	limit := s.HistoryLimit
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	jobs, err := s.JobStore.List(JobQuery{Limit: math.MaxInt32})
+	if err != nil {
+		return err
+	}
+	kept := 0
+	for _, j := range jobs {
+		if j.Status == JobQueued || j.Status == JobRunning {
+			continue
+		}
+		if kept < limit && (s.HistoryMaxAge == 0 || time.Since(j.Start) < s.HistoryMaxAge) {
+			kept++
+			continue
+		}
+		if err := s.JobStore.Delete(j.ID); err != nil {
+			return err
+		}
+		removeArtifacts(j)
+	}
+	return nil
+}
+
+// removeArtifacts removes the output directory of a job. Artifacts are
+// stored at <job directory>/<flag name>/<file name>.
+func removeArtifacts(j Job) {
+	for _, a := range j.Artifacts {
+		if a.Path == "" {
+			continue
+		}
+		dir := filepath.Dir(filepath.Dir(a.Path))
+		if strings.HasPrefix(filepath.Base(dir), "gobra-job") {
+			os.RemoveAll(dir)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	jobsBucket      = []byte("jobs")
+	outputBucket    = []byte("output")
+	schedulesBucket = []byte("schedules")
+)
+
+// boltJob is the record of a job in a BoltStore. The paths of files on
+// the server are not part of the JSON of a Job, so they are stored
+// alongside it.
+type boltJob struct {
+	Job
+	ArtifactPaths []string `json:"artifactPaths,omitempty"`
+	UploadPaths   []string `json:"uploadPaths,omitempty"`
+}
+
+func marshalJob(j Job) ([]byte, error) {
+	r := boltJob{Job: j}
+	for _, a := range j.Artifacts {
+		r.ArtifactPaths = append(r.ArtifactPaths, a.Path)
+	}
+	for _, u := range j.Uploads {
+		r.UploadPaths = append(r.UploadPaths, u.Path)
+	}
+	return json.Marshal(r)
+}
+
+func unmarshalJob(v []byte) (Job, error) {
+	var r boltJob
+	if err := json.Unmarshal(v, &r); err != nil {
+		return Job{}, err
+	}
+	for i, p := range r.ArtifactPaths {
+		if i < len(r.Artifacts) {
+			r.Artifacts[i].Path = p
+		}
+	}
+	for i, p := range r.UploadPaths {
+		if i < len(r.Uploads) {
+			r.Uploads[i].Path = p
+		}
+	}
+	return r.Job, nil
+}
+
+// boltSchedule is the record of a schedule in a BoltStore. Seq orders
+// the schedules by when they were first put.
+type boltSchedule struct {
+	Schedule
+	Seq uint64 `json:"seq"`
+}
+
+// BoltStore is a JobStore and ScheduleStore that keeps jobs and schedules
+// in a BoltDB file. The file holds the flag values of schedules,
+// including those of sensitive flags, which the schedules need to run.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the BoltDB file at path, creating it if necessary.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("gobra: opening job history %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{jobsBucket, outputBucket, schedulesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("gobra: opening job history %s: %v", path, err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the database file.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// Put implements JobStore.
+func (b *BoltStore) Put(j Job) error {
+	v, err := marshalJob(j)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).Put([]byte(j.ID), v)
+	})
+}
+
+// Get implements JobStore.
+func (b *BoltStore) Get(id string) (Job, error) {
+	var j Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(jobsBucket).Get([]byte(id))
+		if v == nil {
+			return ErrJobNotFound
+		}
+		var err error
+		j, err = unmarshalJob(v)
+		return err
+	})
+	return j, err
+}
+
+// List implements JobStore.
+func (b *BoltStore) List(q JobQuery) ([]Job, error) {
+	var jobs []Job
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(jobsBucket).ForEach(func(k, v []byte) error {
+			j, err := unmarshalJob(v)
+			if err != nil {
+				return fmt.Errorf("gobra: reading job %s: %v", k, err)
+			}
+			if q.Matches(j) {
+				jobs = append(jobs, j)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return q.limit(jobs), nil
+}
+
+// PutOutput implements JobStore.
+func (b *BoltStore) PutOutput(id string, output []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(outputBucket).Put([]byte(id), output)
+	})
+}
+
+// Output implements JobStore.
+func (b *BoltStore) Output(id string) ([]byte, error) {
+	var out []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(outputBucket).Get([]byte(id))
+		if v == nil {
+			return ErrJobNotFound
+		}
+		out = append([]byte(nil), v...)
+		return nil
+	})
+	return out, err
+}
+
+// Delete implements JobStore.
+func (b *BoltStore) Delete(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(jobsBucket).Delete([]byte(id)); err != nil {
+			return err
+		}
+		return tx.Bucket(outputBucket).Delete([]byte(id))
+	})
+}
+
+// PutSchedule implements ScheduleStore.
+func (b *BoltStore) PutSchedule(sc Schedule) error {
+	sc.Next = time.Time{}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(schedulesBucket)
+		r := boltSchedule{Schedule: sc}
+		if v := bucket.Get([]byte(sc.ID)); v != nil {
+			var old boltSchedule
+			if err := json.Unmarshal(v, &old); err != nil {
+				return err
+			}
+			r.Seq = old.Seq
+		} else {
+			var err error
+			if r.Seq, err = bucket.NextSequence(); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(sc.ID), v)
+	})
+}
+
+// DeleteSchedule implements ScheduleStore.
+func (b *BoltStore) DeleteSchedule(id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).Delete([]byte(id))
+	})
+}
+
+// Schedules implements ScheduleStore.
+func (b *BoltStore) Schedules() ([]Schedule, error) {
+	var records []boltSchedule
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(schedulesBucket).ForEach(func(k, v []byte) error {
+			var r boltSchedule
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("gobra: reading schedule %s: %v", k, err)
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, k int) bool { return records[i].Seq < records[k].Seq })
+	schedules := make([]Schedule, len(records))
+	for i, r := range records {
+		schedules[i] = r.Schedule
+	}
+	return schedules, nil
+}
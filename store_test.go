@@ -0,0 +1,246 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := gobra.OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	jobs := []gobra.Job{
+		{
+			ID: "1", Commands: []string{"app", "math", "add"}, Flags: url.Values{"num1": {"2"}},
+			User: "ann", Status: gobra.JobSucceeded, Start: start, End: start.Add(time.Second),
+			Artifacts: []gobra.Artifact{{Name: "output", Filename: "sum.txt", URL: "/jobs/1/artifacts/output", Path: "/tmp/gobra-job1/output/sum.txt"}},
+			Uploads:   []gobra.Upload{{Flag: "path", Filename: "in.txt", Path: "/tmp/gobra1/in.txt"}},
+		},
+		{ID: "2", Commands: []string{"app", "fail"}, User: "bob", Status: gobra.JobFailed, Error: "it failed", Start: start.Add(time.Hour)},
+		{ID: "3", Commands: []string{"app", "math", "add"}, User: "bob", Status: gobra.JobSucceeded, Start: start.Add(2 * time.Hour)},
+	}
+	for _, j := range jobs {
+		if err := store.Put(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.PutOutput("1", []byte("4\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The history is still there after reopening the file.
+	store.Close()
+	if store, err = gobra.OpenBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	j, err := store.Get("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(j, jobs[0]) {
+		t.Errorf("job = %+v, want %+v", j, jobs[0])
+	}
+	if _, err := store.Get("4"); err != gobra.ErrJobNotFound {
+		t.Errorf("missing job: error = %v, want ErrJobNotFound", err)
+	}
+	if out, err := store.Output("1"); err != nil || string(out) != "4\n" {
+		t.Errorf("output = %q, %v", out, err)
+	}
+
+	for _, test := range []struct {
+		q    gobra.JobQuery
+		want string
+	}{
+		{gobra.JobQuery{}, "3 2 1"},
+		{gobra.JobQuery{Command: "app/math/add"}, "3 1"},
+		{gobra.JobQuery{Status: gobra.JobFailed}, "2"},
+		{gobra.JobQuery{User: "bob", Limit: 1}, "3"},
+		{gobra.JobQuery{Since: start.Add(time.Hour)}, "3 2"},
+	} {
+		jobs, err := store.List(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, j := range jobs {
+			ids = append(ids, j.ID)
+		}
+		if got := strings.Join(ids, " "); got != test.want {
+			t.Errorf("%+v: jobs %s, want %s", test.q, got, test.want)
+		}
+	}
+
+	if err := store.Delete("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("1"); err != gobra.ErrJobNotFound {
+		t.Errorf("deleted job: error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := store.Output("1"); err != gobra.ErrJobNotFound {
+		t.Errorf("output of deleted job: error = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestHistoryDefault(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	ts := newServer(t, &gobra.Server{})
+	ts.Run("app/echo", url.Values{"text": {"hi"}}).ExpectStatus(gobra.JobSucceeded)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("%s written to the working directory", e.Name())
+	}
+}
+
+func TestMemoryStoreOutput(t *testing.T) {
+	store := gobra.NewMemoryStore()
+	for i := 0; i < 101; i++ {
+		id := strconv.Itoa(i)
+		store.Put(gobra.Job{ID: id})
+		if err := store.PutOutput(id, []byte("output "+id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only the output of the 100 most recent jobs is kept.
+	if _, err := store.Output("0"); err != gobra.ErrJobNotFound {
+		t.Errorf("output of the oldest job: error = %v, want ErrJobNotFound", err)
+	}
+	if _, err := store.Get("0"); err != nil {
+		t.Errorf("oldest job: %v", err)
+	}
+	if out, err := store.Output("1"); err != nil || string(out) != "output 1" {
+		t.Errorf("output = %q, %v", out, err)
+	}
+	// Deleted jobs make room for the output of others.
+	store.Delete("100")
+	store.Put(gobra.Job{ID: "101"})
+	store.PutOutput("101", []byte("output 101"))
+	if _, err := store.Output("1"); err != nil {
+		t.Errorf("output after a job was deleted: %v", err)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	ts := newServer(t, &gobra.Server{HistoryFile: path})
+	if _, ok := ts.Gobra.JobStore.(*gobra.BoltStore); !ok {
+		t.Fatalf("job store = %T, want *gobra.BoltStore", ts.Gobra.JobStore)
+	}
+	ts.Run("app/echo", url.Values{"text": {"hi"}}).ExpectStatus(gobra.JobSucceeded)
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	store := gobra.NewMemoryStore()
+	dir := t.TempDir()
+	now := time.Now()
+	for i, age := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute, 48 * time.Hour} {
+		id := string(rune('a' + i))
+		jobDir := filepath.Join(dir, "gobra-job"+id)
+		if err := os.MkdirAll(filepath.Join(jobDir, "output"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		store.Put(gobra.Job{
+			ID: id, Status: gobra.JobSucceeded, Start: now.Add(-age),
+			Artifacts: []gobra.Artifact{{Name: "output", Path: filepath.Join(jobDir, "output", "sum.txt")}},
+		})
+	}
+	store.Put(gobra.Job{ID: "running", Status: gobra.JobRunning, Start: now.Add(-72 * time.Hour)})
+
+	newServer(t, &gobra.Server{JobStore: store, HistoryLimit: 2})
+	for id, kept := range map[string]bool{"a": true, "b": true, "c": false, "d": false} {
+		_, err := store.Get(id)
+		if kept != (err == nil) {
+			t.Errorf("job %s: kept = %v, want %v", id, err == nil, kept)
+		}
+		_, err = os.Stat(filepath.Join(dir, "gobra-job"+id))
+		if kept != (err == nil) {
+			t.Errorf("output files of job %s: kept = %v, want %v", id, err == nil, kept)
+		}
+	}
+	// Jobs that were running when the server stopped are finished and
+	// then deleted as the oldest.
+	if _, err := store.Get("running"); err != gobra.ErrJobNotFound {
+		t.Errorf("interrupted job: error = %v", err)
+	}
+
+	store = gobra.NewMemoryStore()
+	store.Put(gobra.Job{ID: "new", Status: gobra.JobSucceeded, Start: now})
+	store.Put(gobra.Job{ID: "old", Status: gobra.JobSucceeded, Start: now.Add(-48 * time.Hour)})
+	newServer(t, &gobra.Server{JobStore: store, HistoryMaxAge: 24 * time.Hour})
+	if _, err := store.Get("old"); err != gobra.ErrJobNotFound {
+		t.Errorf("job older than HistoryMaxAge: error = %v", err)
+	}
+	if _, err := store.Get("new"); err != nil {
+		t.Errorf("recent job: %v", err)
+	}
+}
+
+func TestJobPathsHidden(t *testing.T) {
+	dir := t.TempDir()
+	ts := newServer(t, &gobra.Server{OutputDir: dir})
+	r := ts.Run("app/math/add", nil).ExpectStatus(gobra.JobSucceeded)
+	if len(r.Job.Artifacts) != 1 {
+		t.Fatalf("artifacts = %+v", r.Job.Artifacts)
+	}
+	resp, err := ts.Client().Get(ts.URL + "/jobs/" + r.Job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(b), dir) || strings.Contains(string(r.Body), dir) {
+		t.Errorf("job JSON %s contains the output directory %s", b, dir)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// streamJob runs the job and writes its output to w as it is produced,
+// flushing after each write. The last line is "Finished. " if the command
+// succeeded and "Failed: <error>" otherwise. The final status of the job
+// is also sent in the X-Gobra-Status trailer, and any error in the
+// X-Gobra-Error trailer.
+func (s *Server) streamJob(w http.ResponseWriter, r *http.Request, job *job) {
+	flusher, _ := w.(http.Flusher)
+	sub, backlog, _ := s.subscribe(job.ID, 0)
+	defer func() { s.broadcaster.unsubscribe(sub) }()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Trailer", "X-Gobra-Status, X-Gobra-Error")
+	w.WriteHeader(http.StatusOK)
+
+	go s.run(job)
+
+	var lastSeq int64
+	for {
+		for _, m := range backlog {
+			lastSeq = m.Seq
+			switch m.Type {
+			case MessageOutput:
+				io.WriteString(w, m.Data)
+			case MessageJobFinished:
+				if m.Status == JobSucceeded {
+					fmt.Fprint(w, "Finished. \n")
+				} else {
+					fmt.Fprintf(w, "Failed: %s\n", m.Error)
+				}
+				w.Header().Set("X-Gobra-Status", string(m.Status))
+				w.Header().Set("X-Gobra-Error", m.Error)
+				return
+			default:
+				continue
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		select {
+		case m, ok := <-sub.ch:
+			if ok {
+				backlog = []Message{m}
+				continue
+			}
+			// We fell behind; catch up from the stored messages.
+			sub, backlog, _ = s.subscribe(job.ID, lastSeq)
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
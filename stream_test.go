@@ -0,0 +1,101 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/gobratest"
+	"github.com/spf13/cobra"
+)
+
+func TestStreamResponses(t *testing.T) {
+	// The command waits for the first line to be received before it
+	// finishes, so the output must be sent while it runs.
+	received := make(chan struct{})
+	root := &cobra.Command{Use: "app"}
+	root.AddCommand(&cobra.Command{Use: "wait", Run: func(cmd *cobra.Command, args []string) {
+		cmd.Println("first")
+		select {
+		case <-received:
+			cmd.Println("second")
+		case <-time.After(gobratest.Timeout):
+			cmd.Println("not streamed")
+		}
+	}})
+	ts := gobratest.NewServer(t, &gobra.Server{Root: root, StreamResponses: true})
+
+	resp, err := ts.Client().Post(ts.URL+"/app/wait", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	close(received)
+	rest, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "second\nFinished. \n" {
+		t.Errorf("rest of the response = %q", rest)
+	}
+	if got := resp.Trailer.Get("X-Gobra-Status"); got != string(gobra.JobSucceeded) {
+		t.Errorf("X-Gobra-Status = %q, want %q", got, gobra.JobSucceeded)
+	}
+	if got := resp.Trailer.Get("X-Gobra-Error"); got != "" {
+		t.Errorf("X-Gobra-Error = %q, want none", got)
+	}
+}
+
+func TestStreamResponsesFailed(t *testing.T) {
+	ts := newServer(t, &gobra.Server{StreamResponses: true})
+	resp, err := ts.Client().Post(ts.URL+"/app/fail", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !strings.HasSuffix(string(b), "Failed: it failed\n") {
+		t.Errorf("response = %s: %q", resp.Status, b)
+	}
+	if got := resp.Trailer.Get("X-Gobra-Status"); got != string(gobra.JobFailed) {
+		t.Errorf("X-Gobra-Status = %q, want %q", got, gobra.JobFailed)
+	}
+	if got := resp.Trailer.Get("X-Gobra-Error"); got != "it failed" {
+		t.Errorf("X-Gobra-Error = %q, want %q", got, "it failed")
+	}
+}
@@ -0,0 +1,69 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestTimeout(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		Timeout:         50 * time.Millisecond,
+		CommandTimeouts: map[string]time.Duration{"app/echo": 0},
+	})
+	start := time.Now()
+	r := ts.Run("app/sleep", url.Values{"duration": {"10s"}}).ExpectStatus(gobra.JobTimedOut)
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("job took %v to time out", d)
+	}
+	if r.Job.Error == "" {
+		t.Error("timed out job has no error")
+	}
+	ts.Run("app/sleep", url.Values{"duration": {"1ms"}}).ExpectStatus(gobra.JobSucceeded)
+
+	// A command timeout of zero means no limit. The echo command takes
+	// longer than the default timeout.
+	ts.Gobra.Timeout = time.Millisecond
+	ts.Run("app/echo", url.Values{"text": {"hi"}}).ExpectStatus(gobra.JobSucceeded).ExpectOutput("hi")
+}
+
+func TestCommandTimeout(t *testing.T) {
+	ts := newServer(t, &gobra.Server{
+		CommandTimeouts: map[string]time.Duration{"app/sleep": 20 * time.Millisecond},
+	})
+	// The queue time does not count: the second job waits for the first.
+	first := ts.Start("app/sleep/", url.Values{"duration": {"10ms"}})
+	second := ts.Start("app/sleep", url.Values{"duration": {"10ms"}})
+	for _, j := range []*gobra.Job{first, second} {
+		if j := ts.Wait(j.ID); j.Status != gobra.JobSucceeded {
+			t.Errorf("job %s: status = %s, error %q", j.ID, j.Status, j.Error)
+		}
+	}
+	ts.Run("app/sleep", url.Values{"duration": {"1s"}}).ExpectStatus(gobra.JobTimedOut)
+}
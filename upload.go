@@ -0,0 +1,343 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxUploadSize is the default limit on the size of uploaded files.
+const DefaultMaxUploadSize = 10 << 30
+
+// chunkedUploadExpiry is how long an upload may go without receiving data
+// before it is discarded.
+const chunkedUploadExpiry = 24 * time.Hour
+
+// chunkedUpload holds the state of a resumable upload. Data is appended to
+// a temporary file until the declared size is reached, at which point the
+// file is handed to the FileUploadFunc of the server.
+type chunkedUpload struct {
+	mu sync.Mutex
+
+	id       string
+	flagName string
+	flagType string
+	filename string
+
+	// size is the total number of bytes declared when the upload was created
+	// and offset is the number of bytes received so far.
+	size, offset int64
+
+	// tmpPath is where the partial data is stored.
+	tmpPath string
+
+	// path is the location returned by FileUploadFunc once the upload
+	// is complete.
+	path string
+
+	lastActive time.Time
+
+	// busy is set while a chunk is being written, which is done without
+	// holding mu so that a slow client does not block other requests.
+	busy bool
+}
+
+// chunkedUploadStatus is the JSON response of the resumable upload end-points.
+type chunkedUploadStatus struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path,omitempty"`
+}
+
+func (u *chunkedUpload) status() chunkedUploadStatus {
+	return chunkedUploadStatus{ID: u.id, Offset: u.offset, Size: u.size, Path: u.path}
+}
+
+// newID returns a random hexadecimal identifier.
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("gobra: generating identifier: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// writeJSON writes v to w as JSON with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// chunkedUploadHandler implements a simple offset-based resumable upload
+// protocol:
+//
+//	POST /upload/chunked        creates an upload from the form values
+//	                            "filename", "size", "name" and "type".
+//	GET|HEAD /upload/chunked/id reports how many bytes have been stored.
+//	PUT /upload/chunked/id      appends the request body, which must start
+//	                            at the offset given in the Upload-Offset header.
+//
+// Each response carries the Upload-Offset and Upload-Length headers and a
+// JSON status. Once all bytes have been received, the status includes the
+// stored file path under "path", as with the /upload end-point.
+func (s *Server) chunkedUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if s.AllowCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Upload-Offset, "+CSRFHeader)
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length")
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/upload/chunked"), "/")
+
+	switch {
+	case r.Method == http.MethodOptions:
+		return
+	case id == "" && r.Method == http.MethodPost:
+		// Only starting an upload counts towards the rate limit, so
+		// that large files can be sent in many chunks.
+		if !s.allow(w, r, "") {
+			return
+		}
+		s.createChunkedUpload(w, r)
+	case id != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		u, ok := s.chunkedUpload(id)
+		if !ok {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		writeChunkedStatus(w, http.StatusOK, u)
+	case id != "" && r.Method == http.MethodPut:
+		u, ok := s.chunkedUpload(id)
+		if !ok {
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		}
+		s.writeChunk(w, r, u)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeChunkedStatus(w http.ResponseWriter, code int, u *chunkedUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.size, 10))
+	writeJSON(w, code, u.status())
+}
+
+func (s *Server) maxUploadSize() int64 {
+	if s.MaxUploadSize > 0 {
+		return s.MaxUploadSize
+	}
+	return DefaultMaxUploadSize
+}
+
+func (s *Server) chunkedUpload(id string) (*chunkedUpload, bool) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	u, ok := s.uploads[id]
+	return u, ok
+}
+
+func (s *Server) createChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+	if err != nil || size < 0 {
+		http.Error(w, fmt.Sprintf("invalid upload size %q", r.FormValue("size")), http.StatusBadRequest)
+		return
+	}
+	if size > s.maxUploadSize() {
+		http.Error(w, fmt.Sprintf("uploads are limited to %d bytes", s.maxUploadSize()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	filename := filepath.Base(r.FormValue("filename"))
+	if filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		http.Error(w, fmt.Sprintf("invalid upload filename %q", r.FormValue("filename")), http.StatusBadRequest)
+		return
+	}
+	id, err := newID()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f, err := ioutil.TempFile("", "gobra-upload")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("creating upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+
+	u := &chunkedUpload{
+		id:         id,
+		flagName:   r.FormValue("name"),
+		flagType:   r.FormValue("type"),
+		filename:   filename,
+		size:       size,
+		tmpPath:    f.Name(),
+		lastActive: time.Now(),
+	}
+
+	s.uploadsMu.Lock()
+	s.expireChunkedUploads()
+	if s.uploads == nil {
+		s.uploads = make(map[string]*chunkedUpload)
+	}
+	s.uploads[id] = u
+	s.uploadsMu.Unlock()
+
+	w.Header().Set("Location", "/upload/chunked/"+id)
+	writeChunkedStatus(w, http.StatusCreated, u)
+}
+
+// expireChunkedUploads removes uploads that have been idle for longer than
+// chunkedUploadExpiry. Uploads that are receiving a chunk are kept. The
+// caller must hold s.uploadsMu.
+func (s *Server) expireChunkedUploads() {
+	for id, u := range s.uploads {
+		u.mu.Lock()
+		if !u.busy && time.Since(u.lastActive) > chunkedUploadExpiry {
+			os.Remove(u.tmpPath)
+			delete(s.uploads, id)
+		}
+		u.mu.Unlock()
+	}
+}
+
+// writeChunk appends the request body to the upload. Whatever part of the
+// body is received is kept even if the connection drops, so the client can
+// ask for the current offset and resume from there.
+func (s *Server) writeChunk(w http.ResponseWriter, r *http.Request, u *chunkedUpload) {
+	u.mu.Lock()
+	u.lastActive = time.Now()
+	if u.path != "" {
+		writeChunkedStatus(w, http.StatusOK, u)
+		u.mu.Unlock()
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		u.mu.Unlock()
+		http.Error(w, "missing or invalid Upload-Offset header", http.StatusBadRequest)
+		return
+	}
+	if offset != u.offset || u.busy {
+		// Another request is writing to the upload, or the client has
+		// lost track of the offset.
+		writeChunkedStatus(w, http.StatusConflict, u)
+		u.mu.Unlock()
+		return
+	}
+	if r.ContentLength > u.size-u.offset {
+		u.mu.Unlock()
+		http.Error(w, "chunk exceeds the declared upload size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	u.busy = true
+	u.mu.Unlock()
+
+	n, err := appendChunk(u.tmpPath, offset, io.LimitReader(r.Body, u.size-offset))
+	complete := err == nil && offset+n == u.size
+	if complete {
+		// The upload stays busy while it is handed over, so that no
+		// chunk is written to it in the meantime.
+		err = s.finishChunkedUpload(u)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.offset = offset + n
+	u.lastActive = time.Now()
+	u.busy = false
+	if err != nil {
+		if !complete {
+			err = fmt.Errorf("storing chunk: %v", err)
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeChunkedStatus(w, http.StatusOK, u)
+}
+
+// appendChunk writes the data from r to the file at path, starting at
+// offset, and returns the number of bytes written.
+func appendChunk(path string, offset int64, r io.Reader) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0660)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if _, err = f.Seek(offset, io.SeekStart); err == nil {
+		n, err = io.Copy(f, r)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// finishChunkedUpload hands the completed data to FileUploadFunc, or
+// extracts it if the upload is for an archive flag. The caller must have
+// marked the upload busy.
+func (s *Server) finishChunkedUpload(u *chunkedUpload) error {
+	f, err := os.Open(u.tmpPath)
+	if err != nil {
+		return fmt.Errorf("opening completed upload: %v", err)
+	}
+	var path string
+	if s.isArchiveFlag(u.flagName) {
+		path, err = s.extractUpload(f, u.size)
+	} else {
+		path, err = s.FileUploadFunc(f, u.filename)
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed opening/copying uploaded file: %v", err)
+	}
+	os.Remove(u.tmpPath)
+	u.mu.Lock()
+	u.path = path
+	u.mu.Unlock()
+	s.metrics.observeUpload("chunked", u.size)
+	return nil
+}
@@ -0,0 +1,320 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestUpload(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	p := ts.Upload("path", filepath.Join("testdata", "input.txt"))
+	if filepath.Base(p) != "input.txt" {
+		t.Errorf("uploaded path = %q", p)
+	}
+	ts.Run("app/cat", url.Values{"path": {p}}).
+		ExpectStatus(gobra.JobSucceeded).
+		ExpectOutput("hello from a fixture")
+}
+
+func TestUploadSlice(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	w.WriteField("name", "paths")
+	w.WriteField("type", "stringSlice")
+	for _, name := range []string{"input.txt", "input2.txt"} {
+		part, err := w.CreateFormFile("data", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(b)
+	}
+	w.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/upload", w.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	ts.Run("app/cat", url.Values{"paths": {res.Path}}).
+		ExpectStatus(gobra.JobSucceeded).
+		ExpectOutput("hello from a fixture\nsecond fixture\n")
+}
+
+func TestChunkedUpload(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "input.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().PostForm(ts.URL+"/upload/chunked", url.Values{
+		"filename": {"input.txt"},
+		"size":     {strconv.Itoa(len(data))},
+		"name":     {"path"},
+		"type":     {"string"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := decodeChunkedStatus(t, resp, http.StatusCreated)
+	location := resp.Header.Get("Location")
+
+	// Send the file in two chunks, the second one twice as if the first
+	// attempt had failed.
+	half := len(data) / 2
+	for _, chunk := range []struct {
+		offset, end, code int
+	}{
+		{0, half, http.StatusOK},
+		{0, half, http.StatusConflict},
+		{half, len(data), http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+location, bytes.NewReader(data[chunk.offset:chunk.end]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Upload-Offset", strconv.Itoa(chunk.offset))
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if chunk.code != http.StatusOK {
+			resp.Body.Close()
+			if resp.StatusCode != chunk.code {
+				t.Errorf("PUT at offset %d: status code = %d, want %d", chunk.offset, resp.StatusCode, chunk.code)
+			}
+			continue
+		}
+		status = decodeChunkedStatus(t, resp, chunk.code)
+		if status.Offset != int64(chunk.end) {
+			t.Errorf("offset = %d, want %d", status.Offset, chunk.end)
+		}
+	}
+	if status.Path == "" {
+		t.Fatal("no path after the last chunk")
+	}
+	b, err := ioutil.ReadFile(status.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("uploaded file = %q, want %q", b, data)
+	}
+	os.Remove(status.Path)
+}
+
+func TestChunkedUploadStalled(t *testing.T) {
+	ts := newServer(t, &gobra.Server{MaxUploadSize: 100})
+	resp, err := ts.Client().PostForm(ts.URL+"/upload/chunked", url.Values{
+		"filename": {"big.txt"},
+		"size":     {"101"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the limit: status code = %d, want %d", resp.StatusCode, http.StatusRequestEntityTooLarge)
+	}
+
+	resp, err = ts.Client().PostForm(ts.URL+"/upload/chunked", url.Values{
+		"filename": {"slow.txt"},
+		"size":     {"10"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeChunkedStatus(t, resp, http.StatusCreated)
+	location := resp.Header.Get("Location")
+
+	// Start a chunk that stalls after its first bytes.
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest(http.MethodPut, ts.URL+location, pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upload-Offset", "0")
+	done := make(chan *http.Response)
+	go func() {
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	pw.Write([]byte("01234"))
+
+	// Other requests are answered in the meantime.
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err = client.Get(ts.URL + location)
+	if err != nil {
+		t.Fatalf("status of a stalled upload: %v", err)
+	}
+	decodeChunkedStatus(t, resp, http.StatusOK)
+	req2, _ := http.NewRequest(http.MethodPut, ts.URL+location, bytes.NewReader([]byte("0123456789")))
+	req2.Header.Set("Upload-Offset", "0")
+	resp, err = client.Do(req2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeChunkedStatus(t, resp, http.StatusConflict)
+	resp, err = client.PostForm(ts.URL+"/upload/chunked", url.Values{"filename": {"other.txt"}, "size": {"1"}})
+	if err != nil {
+		t.Fatalf("creating an upload during a stalled one: %v", err)
+	}
+	decodeChunkedStatus(t, resp, http.StatusCreated)
+
+	pw.Write([]byte("56789"))
+	pw.Close()
+	status := decodeChunkedStatus(t, <-done, http.StatusOK)
+	if status.Offset != 10 || status.Path == "" {
+		t.Errorf("status after the stalled chunk = %+v", status)
+	}
+	os.Remove(status.Path)
+}
+
+type chunkedStatus struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path"`
+}
+
+func decodeChunkedStatus(t *testing.T, resp *http.Response, code int) chunkedStatus {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		b, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("status code = %d, want %d: %s", resp.StatusCode, code, b)
+	}
+	var s chunkedStatus
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestCrossSiteUpload(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	resp, err := ts.Client().PostForm(ts.URL+"/upload/chunked", url.Values{
+		"filename": {"input.txt"},
+		"size":     {"4"},
+		"name":     {"path"},
+		"type":     {"string"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decodeChunkedStatus(t, resp, http.StatusCreated)
+	location := resp.Header.Get("Location")
+
+	multipartBody := func() (io.Reader, string) {
+		body := new(bytes.Buffer)
+		w := multipart.NewWriter(body)
+		w.WriteField("name", "path")
+		w.WriteField("type", "string")
+		fw, _ := w.CreateFormFile("data", "input.txt")
+		fw.Write([]byte("data"))
+		w.Close()
+		return body, w.FormDataContentType()
+	}
+	for _, test := range []struct {
+		name, method, path string
+		body               func() (io.Reader, string)
+		code               int
+	}{
+		{"multipart", http.MethodPost, "/upload", multipartBody, http.StatusOK},
+		{"multipart without a file", http.MethodPost, "/upload", func() (io.Reader, string) {
+			return bytes.NewReader([]byte("--x--\r\n")), "multipart/form-data; boundary=x"
+		}, http.StatusBadRequest},
+		{"chunked start", http.MethodPost, "/upload/chunked", func() (io.Reader, string) {
+			return bytes.NewReader([]byte("filename=a.txt&size=4&name=path&type=string")), "application/x-www-form-urlencoded"
+		}, http.StatusCreated},
+		{"chunk", http.MethodPut, location, func() (io.Reader, string) {
+			return bytes.NewReader([]byte("data")), "application/octet-stream"
+		}, http.StatusOK},
+	} {
+		for _, h := range []struct {
+			name  string
+			token bool
+		}{
+			{"Sec-Fetch-Site", false},
+			{"Origin", false},
+			{"Sec-Fetch-Site", true},
+		} {
+			body, contentType := test.body()
+			req, err := http.NewRequest(test.method, ts.URL+test.path, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", contentType)
+			if h.name == "Origin" {
+				req.Header.Set("Origin", "http://example.com")
+			} else {
+				req.Header.Set("Sec-Fetch-Site", "cross-site")
+			}
+			code := http.StatusForbidden
+			if h.token {
+				req.Header.Set(gobra.CSRFHeader, ts.Gobra.CSRFToken())
+				code = test.code
+			}
+			if test.method == http.MethodPut {
+				req.Header.Set("Upload-Offset", "0")
+			}
+			resp, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != code {
+				t.Errorf("%s from another site (%s, token %v): status code = %d, want %d", test.name, h.name, h.token, resp.StatusCode, code)
+			}
+		}
+	}
+}
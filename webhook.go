@@ -0,0 +1,177 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// webhookAttempts is the number of times a webhook is tried before
+	// giving up.
+	webhookAttempts = 5
+
+	// webhookBackoff is the time waited before retrying a webhook for the
+	// first time. It doubles after each attempt.
+	webhookBackoff = time.Second
+
+	// webhookTimeout is how long a webhook request may take.
+	webhookTimeout = 30 * time.Second
+)
+
+// WebhookPayload is the JSON body posted to webhooks when a job finishes.
+type WebhookPayload struct {
+	Event string `json:"event"`
+
+	ID string `json:"id"`
+
+	// Command is the command path separated by slashes, as in the URL of
+	// the command.
+	Command  string     `json:"command"`
+	Commands []string   `json:"commands"`
+	Flags    url.Values `json:"flags"`
+	Args     []string   `json:"args,omitempty"`
+
+	Status JobStatus `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+
+	// Duration is the time the job ran for, in seconds.
+	Duration float64 `json:"duration"`
+
+	// URL is the address of the job and its artifacts, which are absolute
+	// if Server.PublicURL is set.
+	URL       string     `json:"url"`
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// notify posts the finished job to the webhooks configured for it.
+func (s *Server) notify(j Job) {
+	command := strings.Join(j.Commands, "/")
+	hooks := append(append([]string(nil), s.Webhooks...), s.CommandWebhooks[command]...)
+	if len(hooks) == 0 {
+		return
+	}
+	base := strings.TrimSuffix(s.PublicURL, "/")
+	p := WebhookPayload{
+		Event:    string(MessageJobFinished),
+		ID:       j.ID,
+		Command:  command,
+		Commands: j.Commands,
+		Flags:    j.Flags,
+		Args:     j.Args,
+		Status:   j.Status,
+		Error:    j.Error,
+		Start:    j.Start,
+		End:      j.End,
+		Duration: j.End.Sub(j.Start).Seconds(),
+		URL:      base + "/jobs/" + j.ID,
+	}
+	for _, a := range j.Artifacts {
+		a.URL = base + a.URL
+		p.Artifacts = append(p.Artifacts, a)
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		s.logger().Error("encoding webhook payload", "job", j.ID, "error", err)
+		return
+	}
+	for _, hook := range hooks {
+		go func(hook string) {
+			if err := s.postWebhook(hook, body); err != nil {
+				s.logger().Error("posting webhook", "job", j.ID, "url", hook, "error", err)
+			}
+		}(hook)
+	}
+}
+
+// postWebhook posts body to the webhook at hook, retrying with
+// exponential backoff if the request fails or the server responds with a
+// server error or too many requests.
+func (s *Server) postWebhook(hook string, body []byte) error {
+	client := s.WebhookClient
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = s.tryWebhook(client, hook, body)
+		if !retry || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// tryWebhook makes one attempt at posting body to the webhook at hook. It
+// reports whether the attempt should be retried if it failed.
+func (s *Server) tryWebhook(client *http.Client, hook string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, hook, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gobra-Event", string(MessageJobFinished))
+	if s.WebhookSecret != "" {
+		req.Header.Set("X-Gobra-Signature", "sha256="+webhookSignature(s.WebhookSecret, body))
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("server responded %s", res.Status)
+	default:
+		return false, fmt.Errorf("server responded %s", res.Status)
+	}
+}
+
+// webhookSignature returns the hex-encoded HMAC-SHA256 of body with the
+// given secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,171 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ctessum/gobra"
+	"github.com/ctessum/gobra/gobratest"
+)
+
+// webhook is a server that receives webhooks, responding with the given
+// status codes in turn and then with 200 OK.
+type webhook struct {
+	*httptest.Server
+	t *testing.T
+
+	mu       sync.Mutex
+	codes    []int
+	attempts int
+	payloads []gobra.WebhookPayload
+	received chan struct{}
+}
+
+func newWebhook(t *testing.T, codes ...int) *webhook {
+	h := &webhook{t: t, codes: codes, received: make(chan struct{}, 10)}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var p gobra.WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("decoding webhook payload: %v", err)
+		}
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+
+		h.mu.Lock()
+		code := http.StatusOK
+		if h.attempts < len(h.codes) {
+			code = h.codes[h.attempts]
+		}
+		h.attempts++
+		h.payloads = append(h.payloads, p)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get("X-Gobra-Signature") != want {
+			t.Errorf("signature = %q, want %q", r.Header.Get("X-Gobra-Signature"), want)
+		}
+		h.mu.Unlock()
+
+		w.WriteHeader(code)
+		if code == http.StatusOK {
+			h.received <- struct{}{}
+		}
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// wait waits for the webhook to be delivered and returns the payloads
+// received.
+func (h *webhook) wait() []gobra.WebhookPayload {
+	h.t.Helper()
+	select {
+	case <-h.received:
+	case <-time.After(gobratest.Timeout):
+		h.t.Fatal("webhook not delivered")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]gobra.WebhookPayload(nil), h.payloads...)
+}
+
+func TestWebhook(t *testing.T) {
+	all := newWebhook(t)
+	add := newWebhook(t)
+	ts := newServer(t, &gobra.Server{
+		Webhooks:        []string{all.URL},
+		CommandWebhooks: map[string][]string{"app/math/add": {add.URL}},
+		WebhookSecret:   "secret",
+		PublicURL:       "https://example.com/",
+	})
+	r := ts.Run("app/math/add/", url.Values{"num1": {"2"}}).ExpectStatus(gobra.JobSucceeded)
+
+	for _, h := range []*webhook{all, add} {
+		payloads := h.wait()
+		if len(payloads) != 1 {
+			t.Fatalf("received %d payloads, want 1", len(payloads))
+		}
+		p := payloads[0]
+		if p.Event != "job-finished" || p.ID != r.Job.ID || p.Command != "app/math/add" ||
+			p.Status != gobra.JobSucceeded || p.Flags.Get("num1") != "2" || p.End.Before(p.Start) {
+			t.Errorf("payload = %+v", p)
+		}
+		if p.URL != "https://example.com/jobs/"+r.Job.ID {
+			t.Errorf("payload URL = %q", p.URL)
+		}
+		if len(p.Artifacts) != 1 || p.Artifacts[0].URL != "https://example.com/jobs/"+r.Job.ID+"/artifacts/output" {
+			t.Errorf("payload artifacts = %+v", p.Artifacts)
+		}
+	}
+
+	// Only the webhooks of the command are notified.
+	ts.Run("app/fail", nil).ExpectStatus(gobra.JobFailed)
+	if p := all.wait(); len(p) != 2 || p[1].Status != gobra.JobFailed || p[1].Error != "it failed" {
+		t.Errorf("payloads = %+v", p)
+	}
+	add.mu.Lock()
+	if add.attempts != 1 {
+		t.Errorf("command webhook called %d times, want 1", add.attempts)
+	}
+	add.mu.Unlock()
+}
+
+func TestWebhookRetry(t *testing.T) {
+	unavailable := newWebhook(t, http.StatusServiceUnavailable)
+	tooMany := newWebhook(t, http.StatusTooManyRequests)
+	rejected := newWebhook(t, http.StatusBadRequest)
+	ts := newServer(t, &gobra.Server{
+		Webhooks:      []string{unavailable.URL, tooMany.URL, rejected.URL},
+		WebhookSecret: "secret",
+	})
+	ts.Run("app/echo", nil).ExpectStatus(gobra.JobSucceeded)
+
+	for _, h := range []*webhook{unavailable, tooMany} {
+		if p := h.wait(); len(p) != 2 || p[0].ID != p[1].ID {
+			t.Errorf("payloads = %+v, want the same payload twice", p)
+		}
+	}
+	// The retries above took a second, by which time the rejected
+	// webhook would have been retried too.
+	time.Sleep(100 * time.Millisecond)
+	rejected.mu.Lock()
+	if rejected.attempts != 1 {
+		t.Errorf("webhook that responded 400 was called %d times, want 1", rejected.attempts)
+	}
+	rejected.mu.Unlock()
+}
@@ -0,0 +1,152 @@
+/*
+MIT License
+
+Copyright (c) 2017 Chris Tessum
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package gobra_test
+
+import (
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ctessum/gobra"
+)
+
+func TestWebsocket(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	j := ts.Start("app/progress", nil)
+	ws := ts.Websocket("job=" + j.ID)
+	if out := ws.Output(j.ID); out != "step 1\nstep 2\nstep 3\n" {
+		t.Errorf("output = %q", out)
+	}
+
+	var seq int64
+	var types []string
+	for _, m := range ws.Messages() {
+		if m.Version != gobra.ProtocolVersion || m.JobID != j.ID {
+			t.Errorf("message = %+v", m)
+		}
+		if m.Seq <= seq {
+			t.Errorf("message %d after message %d", m.Seq, seq)
+		}
+		seq = m.Seq
+		types = append(types, string(m.Type))
+	}
+	want := "job-started output progress output progress output progress job-finished"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("message types = %s, want %s", got, want)
+	}
+
+	p := ws.WaitFor(func(m gobra.Message) bool { return m.Type == gobra.MessageProgress && m.Progress.Done == 3 })
+	if p.Progress.Total != 3 || p.Progress.Message != "stepping" {
+		t.Errorf("progress = %+v", p.Progress)
+	}
+	f := ws.WaitFor(func(m gobra.Message) bool { return m.Type == gobra.MessageJobFinished })
+	if f.Status != gobra.JobSucceeded {
+		t.Errorf("job status = %s", f.Status)
+	}
+}
+
+func TestWebsocketSince(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	j := ts.Start("app/progress", nil)
+	ts.Wait(j.ID)
+
+	all := ts.Websocket("job=" + j.ID)
+	all.Output(j.ID)
+	started := all.WaitFor(func(m gobra.Message) bool { return m.Type == gobra.MessageJobStarted })
+
+	ws := ts.Websocket("job=" + j.ID + "&since=" + strconv.FormatInt(started.Seq, 10))
+	if out := ws.Output(j.ID); out != "step 1\nstep 2\nstep 3\n" {
+		t.Errorf("output = %q", out)
+	}
+	for _, m := range ws.Messages() {
+		if m.Seq <= started.Seq {
+			t.Errorf("received message %d, sent before message %d", m.Seq, started.Seq)
+		}
+	}
+}
+
+func TestServerSentEvents(t *testing.T) {
+	ts := newServer(t, &gobra.Server{})
+	j := ts.Start("app/progress", nil)
+	var out strings.Builder
+	err := ts.API().Stream(context.Background(), j.ID, func(m gobra.Message) error {
+		if m.Type == gobra.MessageOutput {
+			out.WriteString(m.Data)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "step 1\nstep 2\nstep 3\n" {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestWebsocketReplayLimit(t *testing.T) {
+	ts := newServer(t, &gobra.Server{ReplayBytes: 1000})
+	j := ts.Start("app/progress", nil)
+	ts.Wait(j.ID)
+
+	// The 9 messages of the job do not all fit in 1000 bytes.
+	ws := ts.Websocket("job=" + j.ID)
+	ws.Output(j.ID)
+	msgs := ws.Messages()
+	if msgs[0].Type != gobra.MessageError || !strings.Contains(msgs[0].Error, "no longer available") {
+		t.Errorf("first message = %+v, want an error about lost messages", msgs[0])
+	}
+	if n := len(msgs); n < 2 || n > 6 {
+		t.Errorf("received %d messages", n)
+	}
+}
+
+func TestFinishedJobReplayLost(t *testing.T) {
+	// No message fits in one byte, so none of them are kept.
+	ts := newServer(t, &gobra.Server{ReplayBytes: 1})
+	j := ts.Start("app/fail", nil)
+	ts.Wait(j.ID)
+
+	ws := ts.Websocket("job=" + j.ID)
+	f := ws.WaitFor(func(m gobra.Message) bool { return m.Type == gobra.MessageJobFinished })
+	if f.JobID != j.ID || f.Status != gobra.JobFailed || f.Error == "" {
+		t.Errorf("websocket job-finished message = %+v", f)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/jobs/" + j.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// The stream ends after the job-finished event.
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"type":"job-finished"`) || !strings.Contains(string(b), `"status":"failed"`) {
+		t.Errorf("events = %s", b)
+	}
+}